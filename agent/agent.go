@@ -0,0 +1,295 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/watch"
+
+	"github.com/boundary/cascade/message"
+	"github.com/boundary/cascade/roll"
+)
+
+// Number of handled event IDs remembered, Consul only buffers the last
+// 256 user events so this covers a full replay of the buffer
+const seenSize = 512
+
+type Agent struct {
+	Node    string
+	Actions []string
+
+	// Local backend config, used when none is set in KV for the node
+	// or its roles
+	Config *Config
+
+	// Point to point requests answered alongside cm events
+	Messages *message.Listener
+
+	client *api.Client
+	kv     *api.KV
+	event  *api.Event
+	watch  *watch.WatchPlan
+
+	seen    map[string]bool
+	seenIDs []string
+}
+
+func NewAgent(config *Config, actions []string) (*Agent, error) {
+	client, _ := api.NewClient(api.DefaultConfig())
+
+	self, err := client.Agent().Self()
+	if err != nil {
+		return nil, err
+	}
+
+	node := self["Config"]["NodeName"].(string)
+
+	return &Agent{
+		Node:     node,
+		Actions:  actions,
+		Config:   config,
+		Messages: message.NewListener(node),
+		client:   client,
+		kv:       client.KV(),
+		event:    client.Event(),
+		seen:     make(map[string]bool),
+	}, nil
+}
+
+// Run watches for cascade events targeted at this node until stopped
+func (a *Agent) Run() error {
+	watchParams := make(map[string]interface{})
+	watchParams["type"] = "event"
+	watchParams["name"] = roll.EventName
+
+	watch, err := watch.Parse(watchParams)
+	if err != nil {
+		return err
+	}
+
+	a.watch = watch
+
+	a.watch.Handler = func(idx uint64, data interface{}) {
+		events := data.([]*api.UserEvent)
+
+		for _, event := range events {
+			if !a.markSeen(event.ID) {
+				continue
+			}
+
+			var e roll.CascadeEvent
+			if err := json.Unmarshal(event.Payload, &e); err != nil {
+				log.Println("err: ", err)
+				continue
+			}
+
+			// Replies reference the request, requests don't
+			if e.Ref != "" {
+				continue
+			}
+
+			go a.handle(event.ID, e)
+		}
+	}
+
+	// Events already buffered by Consul are history, only react to new ones
+	history, _, err := a.event.List(roll.EventName, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range history {
+		a.markSeen(event.ID)
+	}
+
+	if err := PublishFacts(a.kv, a.Node, LocalFacts(a.Config)); err != nil {
+		log.Println("err: failed to publish facts: ", err)
+	}
+
+	go func() {
+		if err := a.Messages.Run(roll.ConsulHost); err != nil {
+			log.Println("err: ", err)
+		}
+	}()
+
+	log.Printf("cascade agent watching %s events for %s", roll.EventName, a.Node)
+
+	if err := a.watch.Run(roll.ConsulHost); err != nil {
+		return errors.New(fmt.Sprintf("err: querying Consul agent: %s", err))
+	}
+
+	return nil
+}
+
+func (a *Agent) Stop() {
+	a.Messages.Stop()
+
+	if a.watch != nil {
+		a.watch.Stop()
+	}
+}
+
+// markSeen records an event ID, returning false if it was already handled
+func (a *Agent) markSeen(id string) bool {
+	if a.seen[id] {
+		return false
+	}
+
+	a.seen[id] = true
+	a.seenIDs = append(a.seenIDs, id)
+
+	if len(a.seenIDs) > seenSize {
+		delete(a.seen, a.seenIDs[0])
+		a.seenIDs = a.seenIDs[1:]
+	}
+
+	return true
+}
+
+func (a *Agent) handle(id string, e roll.CascadeEvent) {
+	a.reply(id, "meta", 0)
+
+	if !a.allowed(e.Msg) {
+		log.Printf("err: action `%s` not allowed, refusing %s", e.Msg, id)
+		a.reply(id, "fail", 0)
+		return
+	}
+
+	lock, err := AcquireRunLock()
+	if err != nil {
+		log.Printf("%s, refusing %s", err, id)
+		a.reply(id, "fail", 0)
+		return
+	}
+	defer lock.Release()
+
+	params, err := e.Params.Resolve(a.kv)
+	if err != nil {
+		log.Println("err: ", err)
+		a.reply(id, "fail", 0)
+		return
+	}
+
+	backend, err := ResolveBackend(a.client, a.Node, a.Config)
+	if err != nil {
+		log.Println("err: ", err)
+		a.reply(id, "fail", 0)
+		return
+	}
+
+	// Stream output for the operator when the request is part of a roll
+	var output io.Writer = os.Stdout
+	var logs *roll.LogWriter
+	if e.Roll != "" {
+		logs = roll.NewLogWriter(a.kv, e.Roll, a.Node, e.Attempt)
+		output = io.MultiWriter(os.Stdout, logs)
+	}
+
+	a.reply(id, "start", 0)
+	log.Printf("running `%s` for %s (%s)", e.Msg, e.Source, id)
+
+	start := time.Now()
+	result, err := backend.Run(&Run{ID: id, Action: e.Msg, Params: params, Output: output, Revision: e.Revision})
+	if err != nil {
+		fmt.Fprintln(output, err)
+	}
+
+	if rerr := RecordRun(a.kv, a.Node, NewRunRecord(e.Msg, "roll", result, err, time.Since(start))); rerr != nil {
+		log.Println("err: failed to record run: ", rerr)
+	}
+
+	if logs != nil {
+		if err := logs.Close(); err != nil {
+			log.Println("err: ", err)
+		}
+	}
+
+	if err != nil {
+		log.Printf("err: `%s` failed: %s", e.Msg, err)
+		a.reply(id, "fail", 0)
+		return
+	}
+
+	if !result.Success {
+		log.Printf("err: `%s` failed (%s)", e.Msg, id)
+		a.reply(id, "fail", result.Changes)
+		return
+	}
+
+	log.Printf("`%s` succeeded with %d changes (%s)", e.Msg, result.Changes, id)
+	a.reply(id, "success", result.Changes)
+}
+
+func (a *Agent) allowed(action string) bool {
+	for _, allowed := range a.Actions {
+		if action == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *Agent) reply(ref string, msg string, changes int) {
+	payload, _ := json.Marshal(roll.CascadeEvent{Source: a.Node, Msg: msg, Ref: ref, Changes: changes})
+	params := &api.UserEvent{Name: roll.EventName, Payload: payload}
+
+	if _, _, err := a.event.Fire(params, nil); err != nil {
+		log.Printf("err: failed to send `%s` for %s: %s", msg, ref, err)
+	}
+}
+
+// Env exposes the run to the CM command as CASCADE_* environment variables
+func Env(run *Run) []string {
+	env := []string{
+		"CASCADE_ID=" + run.ID,
+		"CASCADE_ACTION=" + run.Action,
+	}
+
+	if run.Revision != "" {
+		env = append(env, "CASCADE_REVISION="+run.Revision)
+	}
+
+	for k, v := range run.Params {
+		env = append(env, fmt.Sprintf("CASCADE_PARAM_%s=%s", envName(k), v))
+	}
+
+	return env
+}
+
+func envName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
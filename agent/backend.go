@@ -0,0 +1,188 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/hashicorp/consul/api"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DefaultConfigFile = "/etc/cascade/agent.yml"
+
+	// Backend config can be set in KV per node, or per role where the
+	// first of the node's roles with a config wins
+	BackendNodePrefix = "cascade/backend/nodes/"
+	BackendRolePrefix = "cascade/backend/roles/"
+)
+
+// Config selects and configures the CM backend for a node
+type Config struct {
+	Backend  string   `yaml:"backend"`
+	Command  string   `yaml:"command"`
+	Args     []string `yaml:"args"`
+	Dir      string   `yaml:"dir"`
+	Manifest string   `yaml:"manifest"`
+	URL      string   `yaml:"url"`
+	Playbook string   `yaml:"playbook"`
+
+	// Shell command printing the revision a run applied, recorded with
+	// the run result
+	RevisionCommand string `yaml:"revision_command"`
+
+	// Use configs from KV, only honoured in the local config file since
+	// anyone able to write them can run commands on the node
+	AllowKV bool `yaml:"allow_kv"`
+}
+
+// Run describes a single CM run handed to a backend
+type Run struct {
+	ID     string
+	Action string
+	Params map[string]string
+	Output io.Writer
+
+	// Revision pinned for the node's role, empty if none
+	Revision string
+}
+
+// Result is how a backend reports a run back to cascade
+type Result struct {
+	Success bool
+	Changes int
+
+	// Revision applied, only known to backends that check out the pin
+	// or with a revision_command, empty otherwise
+	Revision string
+}
+
+type Backend interface {
+	Run(run *Run) (*Result, error)
+}
+
+var backends = map[string]func(*Config) (Backend, error){
+	"shell":        newShellBackend,
+	"chef":         newChefBackend,
+	"puppet":       newPuppetBackend,
+	"ansible-pull": newAnsiblePullBackend,
+	"scripts":      newScriptsBackend,
+}
+
+// NewBackend builds the backend named by the config
+func NewBackend(config *Config) (Backend, error) {
+	name := config.Backend
+	if name == "" {
+		name = "shell"
+	}
+
+	fn, ok := backends[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("err: unknown backend: %s", name))
+	}
+
+	backend, err := fn(config)
+	if err != nil || config.RevisionCommand == "" {
+		return backend, err
+	}
+
+	return &revisionBackend{backend, config.RevisionCommand}, nil
+}
+
+// LoadConfigFile reads a local backend config, a missing file is not an
+// error and yields an empty config
+func LoadConfigFile(path string) (*Config, error) {
+	config := &Config{}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, errors.New(fmt.Sprintf("err: parsing %s: %s", path, err))
+	}
+
+	return config, nil
+}
+
+// LookupConfig returns the KV backend config for a node, falling back to
+// its roles. nil is returned when none is set.
+func LookupConfig(kv *api.KV, node string, roles []string) (*Config, error) {
+	keys := []string{BackendNodePrefix + node}
+	for _, role := range roles {
+		keys = append(keys, BackendRolePrefix+role)
+	}
+
+	for _, key := range keys {
+		pair, _, err := kv.Get(key, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if pair == nil {
+			continue
+		}
+
+		config := &Config{}
+		if err := yaml.Unmarshal(pair.Value, config); err != nil {
+			return nil, errors.New(fmt.Sprintf("err: parsing %s: %s", key, err))
+		}
+
+		return config, nil
+	}
+
+	return nil, nil
+}
+
+// ResolveBackend picks the backend for a run from KV when the local
+// config allows it, falling back to the local config, so KV changes apply
+// without restarting the agent
+func ResolveBackend(client *api.Client, node string, local *Config) (Backend, error) {
+	if !local.AllowKV {
+		return NewBackend(local)
+	}
+
+	services, err := client.Agent().Services()
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	if service, ok := services["cascade"]; ok {
+		roles = service.Tags
+	}
+
+	config, err := LookupConfig(client.KV(), node, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		config = local
+	}
+
+	return NewBackend(config)
+}
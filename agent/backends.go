@@ -0,0 +1,280 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	chefChanges    = regexp.MustCompile(`Client finished, (\d+)/\d+ resources updated`)
+	puppetChanges  = regexp.MustCompile(`(?m)^\s*Changes:\s*\n\s*Total:\s*(\d+)`)
+	ansibleChanges = regexp.MustCompile(`changed=(\d+)`)
+)
+
+// command runs a CM tool for a run, returning its exit code and output
+func command(run *Run, name string, args ...string) (int, []byte, error) {
+	var out bytes.Buffer
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = io.MultiWriter(run.Output, &out)
+	cmd.Stderr = io.MultiWriter(run.Output, &out)
+	cmd.Env = append(os.Environ(), Env(run)...)
+
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		if status, ok := exit.Sys().(interface {
+			ExitStatus() int
+		}); ok {
+			return status.ExitStatus(), out.Bytes(), nil
+		}
+	}
+
+	if err != nil {
+		return -1, out.Bytes(), err
+	}
+
+	return 0, out.Bytes(), nil
+}
+
+func unsupported(backend string, action string) error {
+	return errors.New(fmt.Sprintf("err: %s backend does not support action: %s", backend, action))
+}
+
+// sumMatches adds up the first submatch of every match in output
+func sumMatches(re *regexp.Regexp, output []byte) int {
+	total := 0
+	for _, m := range re.FindAllSubmatch(output, -1) {
+		n, _ := strconv.Atoi(string(m[1]))
+		total += n
+	}
+
+	return total
+}
+
+// revisionBackend fills in the revision a run applied from the output of
+// the configured revision_command
+type revisionBackend struct {
+	Backend
+	command string
+}
+
+func (b *revisionBackend) Run(run *Run) (*Result, error) {
+	result, err := b.Backend.Run(run)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("sh", "-c", b.command)
+	cmd.Env = append(os.Environ(), Env(run)...)
+
+	out, err := cmd.Output()
+	if err != nil {
+		fmt.Fprintln(run.Output, "err: revision_command: ", err)
+		return result, nil
+	}
+
+	result.Revision = strings.TrimSpace(string(out))
+	return result, nil
+}
+
+// shell runs an arbitrary command for every action, the action is
+// available to it as CASCADE_ACTION
+type shellBackend struct {
+	command string
+}
+
+func newShellBackend(config *Config) (Backend, error) {
+	if config.Command == "" {
+		return nil, errors.New("err: shell backend requires a command")
+	}
+
+	return &shellBackend{config.Command}, nil
+}
+
+func (b *shellBackend) Run(run *Run) (*Result, error) {
+	code, _, err := command(run, "/bin/sh", "-c", b.command)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Success: code == 0}, nil
+}
+
+type chefBackend struct {
+	command string
+	args    []string
+}
+
+func newChefBackend(config *Config) (Backend, error) {
+	b := &chefBackend{"chef-client", config.Args}
+	if config.Command != "" {
+		b.command = config.Command
+	}
+
+	return b, nil
+}
+
+func (b *chefBackend) Run(run *Run) (*Result, error) {
+	args := append([]string{}, b.args...)
+
+	switch run.Action {
+	case "run":
+	case "why-run":
+		args = append(args, "--why-run")
+	default:
+		return nil, unsupported("chef", run.Action)
+	}
+
+	code, output, err := command(run, b.command, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Success: code == 0, Changes: sumMatches(chefChanges, output)}, nil
+}
+
+type puppetBackend struct {
+	command  string
+	manifest string
+	args     []string
+}
+
+func newPuppetBackend(config *Config) (Backend, error) {
+	if config.Manifest == "" {
+		return nil, errors.New("err: puppet backend requires a manifest")
+	}
+
+	b := &puppetBackend{"puppet", config.Manifest, config.Args}
+	if config.Command != "" {
+		b.command = config.Command
+	}
+
+	return b, nil
+}
+
+func (b *puppetBackend) Run(run *Run) (*Result, error) {
+	args := append([]string{"apply", "--detailed-exitcodes", "--summarize"}, b.args...)
+
+	switch run.Action {
+	case "run":
+	case "why-run":
+		args = append(args, "--noop")
+	default:
+		return nil, unsupported("puppet", run.Action)
+	}
+
+	code, output, err := command(run, b.command, append(args, b.manifest)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 0 no changes, 2 changes, 4 failures, 6 changes and failures
+	return &Result{Success: code == 0 || code == 2, Changes: sumMatches(puppetChanges, output)}, nil
+}
+
+type ansiblePullBackend struct {
+	command  string
+	url      string
+	playbook string
+	args     []string
+}
+
+func newAnsiblePullBackend(config *Config) (Backend, error) {
+	if config.URL == "" {
+		return nil, errors.New("err: ansible-pull backend requires a url")
+	}
+
+	b := &ansiblePullBackend{"ansible-pull", config.URL, config.Playbook, config.Args}
+	if config.Command != "" {
+		b.command = config.Command
+	}
+
+	return b, nil
+}
+
+func (b *ansiblePullBackend) Run(run *Run) (*Result, error) {
+	args := append([]string{"-U", b.url}, b.args...)
+
+	if run.Revision != "" {
+		args = append(args, "-C", run.Revision)
+	}
+
+	switch run.Action {
+	case "run":
+	case "why-run":
+		args = append(args, "--check")
+	default:
+		return nil, unsupported("ansible-pull", run.Action)
+	}
+
+	if b.playbook != "" {
+		args = append(args, b.playbook)
+	}
+
+	code, output, err := command(run, b.command, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Success: code == 0, Changes: sumMatches(ansibleChanges, output)}
+
+	// checked out with -C, so a successful run applied the pin
+	if result.Success && run.Action == "run" {
+		result.Revision = run.Revision
+	}
+
+	return result, nil
+}
+
+// scripts runs an executable named after the action from a directory
+type scriptsBackend struct {
+	dir string
+}
+
+func newScriptsBackend(config *Config) (Backend, error) {
+	if config.Dir == "" {
+		return nil, errors.New("err: scripts backend requires a dir")
+	}
+
+	return &scriptsBackend{config.Dir}, nil
+}
+
+func (b *scriptsBackend) Run(run *Run) (*Result, error) {
+	script := filepath.Join(b.dir, filepath.Base(run.Action))
+
+	if _, err := os.Stat(script); os.IsNotExist(err) {
+		return nil, unsupported("scripts", run.Action)
+	}
+
+	code, _, err := command(run, script)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Success: code == 0}, nil
+}
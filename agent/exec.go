@@ -0,0 +1,87 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"log"
+	"os/exec"
+
+	"github.com/boundary/cascade/message"
+)
+
+const (
+	ExecMessage = "exec"
+
+	// Keep replies well inside the KV value size limit
+	maxExecOutput = 128 * 1024
+)
+
+type ExecRequest struct {
+	Command string `json:"command"`
+}
+
+type ExecReply struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// HandleExec answers exec messages by running the command with /bin/sh
+func HandleExec(req *message.Message) (interface{}, error) {
+	var body ExecRequest
+	if err := req.Decode(&body); err != nil {
+		return nil, err
+	}
+
+	log.Printf("exec `%s` for %s", body.Command, req.From)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", body.Command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	reply := &ExecReply{}
+
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		if status, ok := exit.Sys().(interface {
+			ExitStatus() int
+		}); ok {
+			reply.ExitCode = status.ExitStatus()
+			err = nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	reply.Stdout = truncate(stdout.String())
+	reply.Stderr = truncate(stderr.String())
+
+	return reply, nil
+}
+
+func truncate(s string) string {
+	if len(s) > maxExecOutput {
+		return s[:maxExecOutput] + "\n[truncated]\n"
+	}
+
+	return s
+}
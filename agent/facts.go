@@ -0,0 +1,88 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Facts describe the host of a node. Agents publish them under
+// cascade/nodes/<node>/facts when they start, Consul node meta isn't
+// available to the Consul API cascade is built with.
+type Facts struct {
+	Hostname string    `json:"hostname"`
+	OS       string    `json:"os"`
+	Arch     string    `json:"arch"`
+	Kernel   string    `json:"kernel"`
+	CPUs     int       `json:"cpus"`
+	Backend  string    `json:"backend"`
+	Time     time.Time `json:"time"`
+}
+
+func FactsKey(node string) string {
+	return NodesPrefix + node + "/facts"
+}
+
+// LocalFacts gathers the facts of this host
+func LocalFacts(config *Config) *Facts {
+	hostname, _ := os.Hostname()
+	kernel, _ := exec.Command("uname", "-r").Output()
+
+	return &Facts{
+		Hostname: hostname,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Kernel:   strings.TrimSpace(string(kernel)),
+		CPUs:     runtime.NumCPU(),
+		Backend:  config.Backend,
+		Time:     time.Now(),
+	}
+}
+
+func PublishFacts(kv *api.KV, node string, facts *Facts) error {
+	value, err := json.Marshal(facts)
+	if err != nil {
+		return err
+	}
+
+	_, err = kv.Put(&api.KVPair{Key: FactsKey(node), Value: value}, nil)
+	return err
+}
+
+// GetFacts returns the facts of a node, nil if its agent hasn't published
+// any
+func GetFacts(kv *api.KV, node string) (*Facts, error) {
+	pair, _, err := kv.Get(FactsKey(node), nil)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+
+	facts := &Facts{}
+	if err := json.Unmarshal(pair.Value, facts); err != nil {
+		return nil, err
+	}
+
+	return facts, nil
+}
@@ -0,0 +1,296 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/boundary/cascade/roll"
+)
+
+const NodesPrefix = "cascade/nodes/"
+
+// RunRecord is the result of a CM run of a node. The last run of any
+// action is stored under cascade/nodes/<node>/last_run and the last
+// converge (the default action) also under last_converge. Outcome is
+// success, fail or error.
+type RunRecord struct {
+	Time     time.Time     `json:"time"`
+	Action   string        `json:"action"`
+	Outcome  string        `json:"outcome"`
+	Duration time.Duration `json:"duration"`
+	Changes  int           `json:"changes"`
+	Revision string        `json:"revision,omitempty"`
+	Source   string        `json:"source"`
+
+	// Revision applied and time of the last successful converge, carried
+	// over from previous records by other runs
+	LastSuccess time.Time `json:"last_success,omitempty"`
+}
+
+func NewRunRecord(action string, source string, result *Result, err error, duration time.Duration) *RunRecord {
+	record := &RunRecord{
+		Time:     time.Now(),
+		Action:   action,
+		Outcome:  "success",
+		Duration: duration,
+		Source:   source,
+	}
+
+	switch {
+	case err != nil:
+		record.Outcome = "error"
+	case !result.Success:
+		record.Outcome = "fail"
+	}
+
+	if result != nil {
+		record.Changes = result.Changes
+		record.Revision = result.Revision
+	}
+
+	return record
+}
+
+func LastRunKey(node string) string {
+	return NodesPrefix + node + "/last_run"
+}
+
+func LastConvergeKey(node string) string {
+	return NodesPrefix + node + "/last_converge"
+}
+
+// converged reports whether a run converged the node
+func (r *RunRecord) converged() bool {
+	return r.Action == roll.DefaultAction && r.Outcome == "success"
+}
+
+// RecordRun stores the result of a run for a node
+func RecordRun(kv *api.KV, node string, record *RunRecord) error {
+	if record.converged() {
+		record.LastSuccess = record.Time
+	} else {
+		last, err := GetConvergeRecord(kv, node)
+		if err != nil {
+			return err
+		}
+
+		if last != nil {
+			record.LastSuccess = last.LastSuccess
+			record.Revision = last.Revision
+		}
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if record.Action == roll.DefaultAction {
+		if _, err := kv.Put(&api.KVPair{Key: LastConvergeKey(node), Value: value}, nil); err != nil {
+			return err
+		}
+	}
+
+	_, err = kv.Put(&api.KVPair{Key: LastRunKey(node), Value: value}, nil)
+	return err
+}
+
+// GetRunRecord returns the last run of any action of a node, nil if it
+// has never reported one
+func GetRunRecord(kv *api.KV, node string) (*RunRecord, error) {
+	pair, _, err := kv.Get(LastRunKey(node), nil)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+
+	return decodeRunRecord(pair.Value)
+}
+
+// GetConvergeRecord returns the last converge of a node, nil if it has
+// never reported one
+func GetConvergeRecord(kv *api.KV, node string) (*RunRecord, error) {
+	for _, key := range []string{LastConvergeKey(node), LastRunKey(node)} {
+		pair, _, err := kv.Get(key, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if pair == nil {
+			continue
+		}
+
+		record, err := decodeRunRecord(pair.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		// last_run of agents that didn't write last_converge
+		if record.Action == roll.DefaultAction {
+			return record, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// GetRunRecords returns the last converge of every node that has reported
+// one
+func GetRunRecords(kv *api.KV) (map[string]*RunRecord, error) {
+	pairs, _, err := kv.List(NodesPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]*RunRecord)
+	for _, pair := range pairs {
+		var node string
+		switch {
+		case strings.HasSuffix(pair.Key, "/last_converge"):
+			node = strings.TrimSuffix(strings.TrimPrefix(pair.Key, NodesPrefix), "/last_converge")
+		case strings.HasSuffix(pair.Key, "/last_run"):
+			node = strings.TrimSuffix(strings.TrimPrefix(pair.Key, NodesPrefix), "/last_run")
+		default:
+			continue
+		}
+
+		record, err := decodeRunRecord(pair.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if record.Action != roll.DefaultAction {
+			continue
+		}
+
+		// last_converge wins over the last_run of older agents
+		if last := records[node]; last == nil || strings.HasSuffix(pair.Key, "/last_converge") {
+			records[node] = record
+		}
+	}
+
+	return records, nil
+}
+
+func decodeRunRecord(value []byte) (*RunRecord, error) {
+	record := &RunRecord{}
+	if err := json.Unmarshal(value, record); err != nil {
+		return nil, err
+	}
+
+	// records written before last_success was tracked
+	if record.converged() && record.LastSuccess.IsZero() {
+		record.LastSuccess = record.Time
+	}
+
+	return record, nil
+}
+
+// LocalRun converges this host directly with its backend rather than
+// through a roll. It works without a Consul agent, using the local
+// config, and only reports the result to Consul when asked to.
+type LocalRun struct {
+	Action string
+	Params map[string]string
+	Config *Config
+	Report bool
+	Output io.Writer
+
+	// Recorded with the result, local unless set
+	Source string
+}
+
+func (l *LocalRun) Run() (*Result, error) {
+	lock, err := AcquireRunLock()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	node, _ := os.Hostname()
+	config := l.Config
+
+	client, _ := api.NewClient(api.DefaultConfig())
+	self, err := client.Agent().Self()
+	online := err == nil
+
+	if online {
+		node = self["Config"]["NodeName"].(string)
+	} else {
+		log.Println("consul agent unavailable, using local config: ", err)
+	}
+
+	var backend Backend
+	var revision string
+	if online {
+		if backend, err = ResolveBackend(client, node, config); err != nil {
+			return nil, err
+		}
+
+		// pinned as for runs dispatched by a roll
+		if revision, err = localRevision(client); err != nil {
+			return nil, err
+		}
+	} else if backend, err = NewBackend(config); err != nil {
+		return nil, err
+	}
+
+	source := l.Source
+	if source == "" {
+		source = "local"
+	}
+
+	start := time.Now()
+	result, err := backend.Run(&Run{ID: "local", Action: l.Action, Params: l.Params, Output: l.Output, Revision: revision})
+
+	if l.Report {
+		if !online {
+			log.Println("err: not reporting, consul agent unavailable")
+		} else if rerr := RecordRun(client.KV(), node, NewRunRecord(l.Action, source, result, err, time.Since(start))); rerr != nil {
+			log.Println("err: failed to report run: ", rerr)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// localRevision returns the revision pinned for this node's roles
+func localRevision(client *api.Client) (string, error) {
+	services, err := client.Agent().Services()
+	if err != nil {
+		return "", err
+	}
+
+	var roles []string
+	if service, ok := services["cascade"]; ok {
+		roles = service.Tags
+	}
+
+	return roll.NodeRevision(client.KV(), roles)
+}
@@ -0,0 +1,59 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// LockFile guards CM runs on a host, it is shared by the agent and
+// `cm local` so only one run happens at a time
+var LockFile = "/var/lock/cascade.lock"
+
+var ErrRunInProgress = errors.New("err: a cm run is already in progress on this host")
+
+type RunLock struct {
+	file *os.File
+}
+
+// AcquireRunLock takes the host run lock without waiting
+func AcquireRunLock() (*RunLock, error) {
+	file, err := os.OpenFile(LockFile, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrRunInProgress
+		}
+
+		return nil, err
+	}
+
+	return &RunLock{file}, nil
+}
+
+func (l *RunLock) Release() error {
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	return l.file.Close()
+}
@@ -0,0 +1,180 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/boundary/cascade/message"
+)
+
+const (
+	PushMessage = "push"
+	FilesPrefix = "cascade/files/"
+
+	// KV values are limited to 512KB
+	fileChunkSize = 256 * 1024
+)
+
+// PushRequest asks a node to materialize a file stored in KV
+type PushRequest struct {
+	Key      string `json:"key"`
+	Chunks   int    `json:"chunks"`
+	Checksum string `json:"checksum"`
+	Path     string `json:"path"`
+	Mode     uint32 `json:"mode"`
+	Owner    string `json:"owner,omitempty"`
+}
+
+type PushReply struct {
+	Checksum string `json:"checksum"`
+}
+
+// StoreFile writes file content to KV in chunks under a prefix named by
+// its checksum, filling in the request's Key, Chunks and Checksum
+func StoreFile(kv *api.KV, data []byte, req *PushRequest) error {
+	sum := sha256.Sum256(data)
+	req.Checksum = hex.EncodeToString(sum[:])
+	req.Key = FilesPrefix + req.Checksum + "/"
+	req.Chunks = 0
+
+	for offset := 0; offset < len(data) || req.Chunks == 0; offset += fileChunkSize {
+		end := offset + fileChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		key := fmt.Sprintf("%s%06d", req.Key, req.Chunks)
+		if _, err := kv.Put(&api.KVPair{Key: key, Value: data[offset:end]}, nil); err != nil {
+			return err
+		}
+
+		req.Chunks++
+	}
+
+	return nil
+}
+
+// HandlePush answers push messages by reassembling the file from KV,
+// verifying its checksum and atomically replacing the target path
+func (a *Agent) HandlePush(req *message.Message) (interface{}, error) {
+	var body PushRequest
+	if err := req.Decode(&body); err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(body.Path) {
+		return nil, errors.New(fmt.Sprintf("err: remote path must be absolute: %s", body.Path))
+	}
+
+	log.Printf("push %s to %s for %s", body.Checksum, body.Path, req.From)
+
+	var data bytes.Buffer
+	for i := 0; i < body.Chunks; i++ {
+		key := fmt.Sprintf("%s%06d", body.Key, i)
+
+		pair, _, err := a.kv.Get(key, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if pair == nil {
+			return nil, errors.New(fmt.Sprintf("err: missing chunk %s", key))
+		}
+
+		data.Write(pair.Value)
+	}
+
+	sum := sha256.Sum256(data.Bytes())
+	if checksum := hex.EncodeToString(sum[:]); checksum != body.Checksum {
+		return nil, errors.New(fmt.Sprintf("err: checksum mismatch: got %s", checksum))
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(body.Path), ".cascade-push")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data.Bytes()); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(tmp.Name(), os.FileMode(body.Mode)); err != nil {
+		return nil, err
+	}
+
+	if body.Owner != "" {
+		uid, gid, err := lookupOwner(body.Owner)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.Chown(tmp.Name(), uid, gid); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), body.Path); err != nil {
+		return nil, err
+	}
+
+	return &PushReply{Checksum: body.Checksum}, nil
+}
+
+// lookupOwner resolves user[:group], -1 leaves the group unchanged
+func lookupOwner(owner string) (int, int, error) {
+	parts := strings.SplitN(owner, ":", 2)
+
+	u, err := user.Lookup(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	uid, _ := strconv.Atoi(u.Uid)
+	gid := -1
+
+	if len(parts) == 2 && parts[1] != "" {
+		g, err := user.LookupGroup(parts[1])
+		if err != nil {
+			return 0, 0, err
+		}
+
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	return uid, gid, nil
+}
@@ -0,0 +1,282 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/boundary/cascade/roll"
+)
+
+// SemaphorePrefix holds a semaphore per role, cascade/semaphore/<role>,
+// capping how many of its nodes converge on schedule at once
+const SemaphorePrefix = "cascade/semaphore/"
+
+// Scheduler converges the node every Interval, delayed by up to Splay so
+// nodes don't start together. At most Limit nodes of each of the node's
+// roles run at once, and runs are skipped while a roll holds the lock,
+// changes are frozen, the node is cordoned or outside its maintenance
+// windows.
+type Scheduler struct {
+	Interval time.Duration
+	Splay    time.Duration
+	Limit    int
+	Action   string
+	Config   *Config
+
+	client *api.Client
+	rand   *rand.Rand
+	stop   chan struct{}
+}
+
+func NewScheduler(config *Config, action string, interval time.Duration, splay time.Duration, limit int) *Scheduler {
+	client, _ := api.NewClient(api.DefaultConfig())
+
+	if limit < 1 {
+		limit = 1
+	}
+
+	return &Scheduler{
+		Interval: interval,
+		Splay:    splay,
+		Limit:    limit,
+		Action:   action,
+		Config:   config,
+		client:   client,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Run converges on schedule until stopped
+func (s *Scheduler) Run() {
+	log.Printf("cascade scheduler converging every %s (splay %s, limit %d per role)", s.Interval, s.Splay, s.Limit)
+
+	for {
+		wait := s.Interval
+		if s.Splay > 0 {
+			wait += time.Duration(s.rand.Int63n(int64(s.Splay)))
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-s.stop:
+			return
+		}
+
+		if err := s.converge(); err != nil {
+			log.Println("err: scheduled run: ", err)
+		}
+	}
+}
+
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
+
+func (s *Scheduler) converge() error {
+	if holder, err := s.rolling(); err != nil {
+		return err
+	} else if holder != "" {
+		log.Printf("skipping scheduled run, %s is rolling", holder)
+		return nil
+	}
+
+	// Freezes, maintenance windows and cordons hold back scheduled runs
+	// as they do rolls
+	if reason, err := s.held(); err != nil {
+		return err
+	} else if reason != "" {
+		log.Printf("skipping scheduled run, %s", reason)
+		return nil
+	}
+
+	roles, err := s.roles()
+	if err != nil {
+		return err
+	}
+
+	// Closed if a semaphore's session is invalidated
+	held := make(map[string]<-chan struct{})
+
+	// Take the role semaphores in a fixed order so nodes sharing roles
+	// can't deadlock
+	for _, role := range roles {
+		sem, err := s.client.SemaphorePrefix(SemaphorePrefix+role, s.Limit)
+		if err != nil {
+			return err
+		}
+
+		lost, err := sem.Acquire(s.stop)
+		if err != nil {
+			return err
+		}
+
+		// nil when stopped while waiting
+		if lost == nil {
+			return nil
+		}
+
+		defer sem.Release()
+		held[role] = lost
+	}
+
+	for role, lost := range held {
+		select {
+		case <-lost:
+			return errors.New(fmt.Sprintf("err: lost the %s semaphore before running", role))
+		default:
+		}
+	}
+
+	// A run can't be safely interrupted, so a semaphore lost meanwhile is
+	// only reported
+	done := make(chan struct{})
+	defer close(done)
+
+	for role, lost := range held {
+		go func(role string, lost <-chan struct{}) {
+			select {
+			case <-lost:
+				// released after the run
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				log.Printf("err: lost the %s semaphore during the scheduled run, other nodes of the role may run concurrently", role)
+			case <-done:
+			}
+		}(role, lost)
+	}
+
+	// A roll may have started while we waited
+	if holder, err := s.rolling(); err != nil {
+		return err
+	} else if holder != "" {
+		log.Printf("skipping scheduled run, %s is rolling", holder)
+		return nil
+	}
+
+	log.Printf("starting scheduled %s", s.Action)
+
+	local := &LocalRun{
+		Action: s.Action,
+		Config: s.Config,
+		Report: true,
+		Output: os.Stdout,
+		Source: "schedule",
+	}
+
+	result, err := local.Run()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("scheduled %s finished (success: %t, changes: %d)", s.Action, result.Success, result.Changes)
+	return nil
+}
+
+// rolling returns who holds the roll lock, empty if nobody does
+func (s *Scheduler) rolling() (string, error) {
+	pair, _, err := s.client.KV().Get(roll.RollKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if pair == nil || pair.Session == "" {
+		return "", nil
+	}
+
+	return string(pair.Value), nil
+}
+
+// held returns why the node mustn't converge now, empty if it may
+func (s *Scheduler) held() (string, error) {
+	kv := s.client.KV()
+	now := time.Now()
+
+	self, err := s.client.Agent().Self()
+	if err != nil {
+		return "", err
+	}
+
+	node := self["Config"]["NodeName"].(string)
+
+	freeze, err := roll.GetFreeze(kv)
+	if err != nil {
+		return "", err
+	}
+
+	if frozen := freeze.Frozen(now); frozen != "" {
+		return "changes are " + frozen, nil
+	}
+
+	cordons, err := roll.GetCordons(kv)
+	if err != nil {
+		return "", err
+	}
+
+	if cordon := cordons[node]; cordon != nil {
+		return fmt.Sprintf("cordoned by %s: %s", cordon.User, cordon.Reason), nil
+	}
+
+	schedule, err := roll.LoadSchedule(s.client)
+	if err != nil {
+		return "", err
+	}
+
+	switch next := schedule.NextEligible(node, now); {
+	case next.IsZero():
+		return "maintenance windows never open", nil
+	case next.After(now):
+		return fmt.Sprintf("outside maintenance windows until %s", next.Format(time.RFC3339)), nil
+	}
+
+	return "", nil
+}
+
+// roles returns the node's roles sorted, nodes without roles share the
+// `none` semaphore
+func (s *Scheduler) roles() ([]string, error) {
+	services, err := s.client.Agent().Services()
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	if service, ok := services["cascade"]; ok {
+		roles = append(roles, service.Tags...)
+	}
+
+	if len(roles) == 0 {
+		roles = []string{"none"}
+	}
+
+	sort.Strings(roles)
+	return roles, nil
+}
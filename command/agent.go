@@ -0,0 +1,146 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jwaldrip/odin/cli"
+
+	"github.com/boundary/cascade/agent"
+	"github.com/boundary/cascade/roll"
+)
+
+func newAgent() *cli.SubCommand {
+	cmd := cli.NewSubCommand("agent", "Node side cm agent", agentRun)
+	cmd.DefineStringFlag("config", agent.DefaultConfigFile, "local backend config")
+
+	cmd.DefineStringFlag("command", "", "shell command to execute for each run (overrides config)")
+	cmd.AliasFlag('c', "command")
+
+	cmd.DefineStringFlag("actions", roll.DefaultAction, "comma separated actions this node will execute")
+
+	cmd.DefineBoolFlag("allow-exec", false, "answer `cascade exec` requests")
+	cmd.DefineBoolFlag("allow-push", false, "answer `cascade push` requests")
+
+	cmd.DefineDurationFlag("interval", 0, "also converge on this interval (0 disables)")
+	cmd.DefineDurationFlag("splay", 0, "random delay added to each scheduled run")
+	cmd.DefineIntFlag("limit", 1, "most nodes of a role converging on schedule at once")
+
+	cmd.SetLongDescription(`
+Answer cascade cm events targeted at this node
+
+Runs happen one at a time through a CM backend, configured in the local
+--config file:
+
+  backend: chef | puppet | ansible-pull | scripts | shell
+  command: override the backend binary (or the shell command)
+  args: [extra, arguments]
+  manifest: puppet manifest to apply
+  url: ansible-pull repository url
+  playbook: ansible-pull playbook
+  dir: scripts directory, <dir>/<action> is executed
+  revision_command: shell command printing the revision a run applied
+    (ansible-pull checks out the pinned revision, other backends need
+    this for cm roll --only-outdated and rollback to know what they ran)
+  allow_kv: true to prefer configs from KV (local file only)
+
+With allow_kv the config is looked up in KV under cascade/backend/nodes/<node>
+or cascade/backend/roles/<role> first. Anyone with write access to those
+keys can then run any command on the node as the agent's user, so only
+enable it where KV writes are restricted with ACLs.
+
+chef, puppet and ansible-pull support the run and why-run actions. The
+request is available to commands through the environment:
+
+  CASCADE_ID - id of the request event
+  CASCADE_ACTION - requested action (run, why-run, ...)
+  CASCADE_PARAM_<KEY> - parameters passed with cm roll -p key=value
+  CASCADE_REVISION - revision pinned for the node's role, if any
+
+Progress is replied to the operator as meta, start, success or fail,
+and the result recorded under cascade/nodes/<node>/last_run, and also
+under cascade/nodes/<node>/last_converge for the run action.
+
+On start the agent publishes the host's facts (hostname, os, arch,
+kernel, cpus, backend) under cascade/nodes/<node>/facts for node info.
+
+With --allow-exec the node also runs ad-hoc commands sent by cascade exec,
+and with --allow-push writes files sent by cascade push.
+
+With --interval the node also converges periodically with the first of
+--actions, reporting to cascade/nodes/<node>/last_run. At most --limit
+nodes of each role run at once, coordinated through a semaphore under
+cascade/semaphore/<role> (all nodes of a role must use the same limit),
+and scheduled runs are skipped while a roll holds cascade/roll, changes
+are frozen, the node is cordoned or outside its roles' maintenance
+windows.
+  `)
+
+	return cmd
+}
+
+func agentRun(c cli.Command) {
+	actions := strings.Split(c.Flag("actions").String(), ",")
+
+	config, err := agent.LoadConfigFile(c.Flag("config").String())
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if command := c.Flag("command").String(); command != "" {
+		config = &agent.Config{Backend: "shell", Command: command}
+	}
+
+	a, err := agent.NewAgent(config, actions)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if c.Flag("allow-exec").Get() == true {
+		a.Messages.Handle(agent.ExecMessage, agent.HandleExec)
+	}
+
+	if c.Flag("allow-push").Get() == true {
+		a.Messages.Handle(agent.PushMessage, a.HandlePush)
+	}
+
+	var scheduler *agent.Scheduler
+	if interval := c.Flag("interval").Get().(time.Duration); interval > 0 {
+		scheduler = agent.NewScheduler(config, actions[0], interval, c.Flag("splay").Get().(time.Duration), c.Flag("limit").Get().(int))
+		go scheduler.Run()
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ch
+		if scheduler != nil {
+			scheduler.Stop()
+		}
+		a.Stop()
+	}()
+
+	if err := a.Run(); err != nil {
+		fatalln("err: ", err)
+	}
+}
@@ -18,35 +18,95 @@
 package command
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/jwaldrip/odin/cli"
 
+	"github.com/boundary/cascade/agent"
 	"github.com/boundary/cascade/roll"
 )
 
-var Cm = cli.NewSubCommand("cm", "Config management operations", cmRun)
+func newCm() *cli.SubCommand {
+	cmd := cli.NewSubCommand("cm", "Config management operations", cmRun)
+	cmd.DefineParams("action")
+	cmd.DefineStringFlag("role", "", "filter by role")
+	cmd.AliasFlag('r', "role")
 
-func init() {
-	Cm.DefineParams("action")
-	Cm.DefineStringFlag("role", "", "filter by role")
-	Cm.AliasFlag('r', "role")
+	cmd.DefineBoolFlag("force", false, "perform `roll` operation even if no `role` filter is set")
+	cmd.AliasFlag('f', "force")
 
-	Cm.DefineBoolFlag("force", false, "perform `roll` operation even if no `role` filter is set")
-	Cm.AliasFlag('f', "force")
+	cmd.DefineStringFlag("action", roll.DefaultAction, "named action for nodes to execute (e.g. why-run, restart-service)")
+	cmd.AliasFlag('a', "action")
 
-	Cm.SetLongDescription(`
+	cmd.DefineFlag(paramFlag{}, "param", "key=value parameter passed to the node side run (repeatable)")
+	cmd.AliasFlag('p', "param")
+
+	cmd.DefineBoolFlag("override-freeze", false, "start even if changes are frozen, recorded in history")
+
+	cmd.DefineBoolFlag("wait-window", false, "hold nodes outside their role's maintenance window until it opens")
+
+	cmd.DefineStringFlag("pause-after", "", "wait for approval after each `batch`, each run_order `role`, or the named roles (comma separated)")
+	cmd.DefineBoolFlag("two-person", false, "gates must be approved by a different operator (advisory)")
+
+	cmd.DefineBoolFlag("now", false, "`abort` immediately rather than after the current node")
+
+	cmd.DefineBoolFlag("follow", false, "keep showing `status` until the roll finishes")
+
+	cmd.DefineDurationFlag("older-than", 24*time.Hour, "`drift` lists nodes without a successful run for this long")
+
+	cmd.DefineBoolFlag("only-outdated", false, "`roll` only nodes that last applied a revision other than their role's pin")
+
+	cmd.DefineBoolFlag("rollback-on-failure", false, "roll back converged nodes to their previous revision if the `roll` fails")
+
+	cmd.DefineBoolFlag("plan", false, "show the nodes a `roll` would run on without running it")
+
+	cmd.DefineBoolFlag("quiet", false, "don't stream node CM output")
+	cmd.AliasFlag('q', "quiet")
+
+	cmd.DefineStringFlag("config", agent.DefaultConfigFile, "backend config for `local` runs")
+	cmd.DefineBoolFlag("report", false, "report `local` run results to Consul")
+
+	cmd.DefineIntFlag("retries", 0, "re-dispatch a failed or timed out node up to `retries` times")
+	cmd.DefineDurationFlag("retry-delay", 10*time.Second, "wait between retries")
+	cmd.DefineDurationFlag("timeout", 0, "consider a node timed out after this long (0 waits forever)")
+
+	cmd.SetLongDescription(`
 Run CM on member systems
 
 Actions:
-  roll - ordered synchronous run
-  local - run CM locally only
+  roll - ordered synchronous run (use --action to run something other than CM)
+  local - run CM directly on this host, works without a Consul agent
   single <nodename> - run on single remote node
+  logs <roll id> <nodename> - replay CM output of a node from a roll (kept 7 days)
+  history [<roll id>] - list previous rolls (the last 1000) or show one
+  approve <roll id> - continue a roll waiting at a --pause-after gate
+  reject <roll id> - abort a roll waiting at a --pause-after gate
+  drift - list nodes by role that failed or haven't converged recently
+  rollback <roll id> - re-roll the nodes a roll converged, in reverse order, with their previous revision
+  status - show the progress of the running roll (--follow to keep watching)
+  abort - stop the running roll after the current node (--now to abort immediately)
+
+A roll waiting at a gate can also be continued or aborted by typing
+continue or abort into the terminal running it, unless --two-person is
+set which requires another operator to approve.
+
+--two-person is advisory: operators are identified by $USER (or
+$SUDO_USER), which anyone can set, and the approval is a plain KV key. It
+prevents mistakes rather than misuse, restrict writes to cascade/approval/
+with Consul ACLs where that matters.
   `)
+
+	return cmd
 }
 
 func cmRun(c cli.Command) {
@@ -57,101 +117,768 @@ func cmRun(c cli.Command) {
 		cmRoll(c)
 	case "single":
 		cmSingle(c)
+	case "logs":
+		cmLogs(c)
+	case "history":
+		cmHistory(c)
+	case "approve":
+		cmDecide(c, "continue")
+	case "reject":
+		cmDecide(c, "abort")
+	case "abort":
+		cmAbort(c)
+	case "status":
+		cmStatus(c)
+	case "drift":
+		cmDrift(c)
+	case "rollback":
+		cmRollback(c)
 	default:
 		cli.ShowUsage(c)
 	}
 }
 
 func cmLocal(c cli.Command) {
-	client, _ := api.NewClient(api.DefaultConfig())
-	agent := client.Agent()
-
-	self, err := agent.Self()
+	action := c.Flag("action").String()
+	if err := roll.ValidateAction(action); err != nil {
+		fatalln(err)
+	}
 
+	config, err := agent.LoadConfigFile(c.Flag("config").String())
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
-	services, err := agent.Services()
+	local := &agent.LocalRun{
+		Action: action,
+		Params: c.Flag("param").Get().(map[string]string),
+		Config: config,
+		Report: c.Flag("report").Get() == true,
+		Output: os.Stdout,
+	}
+
+	if c.Flag("quiet").Get() == true {
+		local.Output = ioutil.Discard
+	}
+
+	result, err := local.Run()
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
-	if _, ok := services["cascade"]; !ok {
-		log.Fatalln("Node not managed by cascade")
+	if !result.Success {
+		fatalf("Local %s failed (changes: %d)\n", action, result.Changes)
 	}
 
-	cmRunRoll("", self["Config"]["NodeName"].(string))
+	fmt.Printf("Local %s succeeded (changes: %d)\n", action, result.Changes)
 }
 
 func cmRoll(c cli.Command) {
 	role := c.Flag("role").String()
 	if (len(role) == 0 && c.Flag("force").Get() != true) {
-		log.Fatalln("Must specify -f option to run with no `role` filter specified")
+		fatalln("Must specify -f option to run with no `role` filter specified")
+	} else if c.Flag("plan").Get() == true {
+		cmPlan(role)
 	} else {
-		cmRunRoll(role, "")
+		cmRunRoll(c, role, "")
+	}
+}
+
+func cmPlan(role string) {
+	nodes, err := roll.GetNodes(role)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	cordoned, err := roll.GetCordoned(role)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	client := consulClient()
+
+	schedule, err := roll.LoadSchedule(client)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	now := time.Now()
+
+	fmt.Printf("Would roll (%v) nodes:\n", len(nodes))
+	for _, node := range nodes {
+		next := schedule.NextEligible(node, now)
+
+		switch {
+		case next.Equal(now):
+			fmt.Println("  -", node)
+		case next.IsZero():
+			fmt.Printf("  - %s (no open maintenance window)\n", node)
+		default:
+			fmt.Printf("  - %s (next eligible %s)\n", node, next.Format(time.RFC3339))
+		}
+	}
+
+	if len(cordoned) > 0 {
+		fmt.Printf("Skipping (%v) cordoned nodes:\n", len(cordoned))
+		for _, cordon := range cordoned {
+			fmt.Printf("  - %s (%s)\n", cordon.Node, cordon)
+		}
 	}
 }
 
 func cmSingle(c cli.Command) {
-	client, _ := api.NewClient(api.DefaultConfig())
+	client := consulClient()
 	catalog := client.Catalog()
 
 	node, _, err := catalog.Node(c.Arg(0).String(), nil)
 
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
 	if node == nil {
-		log.Fatalln("node not found")
+		fatalln("node not found")
 	}
 
 	if node.Services["cascade"] == nil {
-		log.Fatalln("node not managed by cascade")
+		fatalln("node not managed by cascade")
+	}
+
+	cordons, err := roll.GetCordons(client.KV())
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if cordon := cordons[node.Node.Node]; cordon != nil && c.Flag("force").Get() != true {
+		fatalf("node is %s, use -f to run anyway\n", cordon)
 	}
 
-	cmRunRoll("", c.Arg(0).String())
+	cmRunRoll(c, "", c.Arg(0).String())
 }
 
-func cmRunRoll(role string, host string) {
-	roller, err := roll.NewRoll(role)
-	defer roller.Destroy()
+func cmRunRoll(c cli.Command, role string, host string) {
+	action := c.Flag("action").String()
+	if err := roll.ValidateAction(action); err != nil {
+		fatalln(err)
+	}
 
+	roller, err := roll.NewRoll(role, c.Flag("override-freeze").Get() == true)
 	if err != nil {
-		log.Fatalln("Err: ", err)
+		fatalln("Err: ", err)
 	}
+	defer roller.Destroy()
 
 	if host != "" {
 		roller.Nodes = []string{host}
 	}
 
-	// Setup interupt channel
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
-	go func() {
-		<-ch
+	applied, err := appliedRevisions()
+	if err != nil {
 		roller.Destroy()
-		os.Exit(0)
-	}()
+		fatalln("err: ", err)
+	}
 
-	// Setup render channel
+	if c.Flag("only-outdated").Get() == true {
+		roller.Nodes = outdatedNodes(roller, applied)
+
+		if len(roller.Nodes) == 0 {
+			fmt.Println("All nodes are up to date")
+			return
+		}
+	}
+
+	roller.Action = action
+	roller.Params = c.Flag("param").Get().(map[string]string)
+	roller.Retries = c.Flag("retries").Get().(int)
+	roller.RetryDelay = c.Flag("retry-delay").Get().(time.Duration)
+	roller.Timeout = c.Flag("timeout").Get().(time.Duration)
+	roller.WaitForWindow = c.Flag("wait-window").Get() == true
+	roller.TwoPerson = c.Flag("two-person").Get() == true
+
+	roller.Previous = applied
+
+	if pause := c.Flag("pause-after").String(); pause != "" {
+		roller.PauseAfter = strings.Split(pause, ",")
+	}
+
+	if err := roller.CheckPauseAfter(); err != nil {
+		roller.Destroy()
+		fatalln(err)
+	}
+
+	err = runRoll(c, roller)
+	if err == nil {
+		return
+	}
+
+	roller.Destroy()
+
+	if c.Flag("rollback-on-failure").Get() == true && err != roll.ErrRollStopped && err != roll.ErrRollAborted {
+		fmt.Println("Roll failed, rolling back")
+
+		if rerr := cmRunRollback(c, roller.ID); rerr != nil {
+			log.Println("Rollback err:", rerr)
+		}
+	}
+
+	fatal("Roll err:", err)
+}
+
+// runRoll rolls with progress rendered, stopping on the first interrupt
+// and aborting on the second
+func runRoll(c cli.Command, roller *roll.Roll) error {
+	client := consulClient()
+	kv := client.KV()
+
+	ctx, cancel := interruptible(roller)
+	defer cancel()
+
+	// Decisions typed while paused go through KV like those of other
+	// operators, stdin is only read until the gate ends
+	gate := &prompt{}
+	defer gate.end()
+
+	// Setup render channel, tailing node output between start and result
+	quiet := c.Flag("quiet").Get() == true
 	go func() {
+		var host string
+		var attempt int
+		var stop, done chan struct{}
+
+		stopTail := func() {
+			if stop != nil {
+				close(stop)
+				<-done
+				stop = nil
+			}
+		}
+
 		for msg := range roller.Msg {
+			if !strings.HasPrefix(msg, roll.PauseMsg+" ") && !strings.HasPrefix(msg, roll.IgnoredMsg+" ") {
+				gate.end()
+			}
+
+			if strings.HasPrefix(msg, roll.WindowMsg+" ") {
+				fields := strings.Fields(msg)
+				fmt.Printf("%s: waiting for maintenance window until %s\n", fields[1], fields[2])
+				continue
+			}
+
+			if strings.HasPrefix(msg, roll.PauseMsg+" ") {
+				stopTail()
+				fmt.Printf("Paused after %s, type `continue` or `abort` (or `cascade cm approve %s`):\n", strings.TrimPrefix(msg, roll.PauseMsg+" "), roller.ID)
+
+				gate.start(func(line string) bool {
+					if decision := strings.TrimSpace(line); decision != "" {
+						if err := roll.Decide(kv, roller.ID, roll.CurrentUser(), decision); err != nil {
+							fmt.Println(err)
+						}
+					}
+
+					return true
+				})
+				continue
+			}
+
+			if strings.HasPrefix(msg, roll.AbortMsg+" ") {
+				fields := strings.SplitN(msg, " ", 3)
+				if fields[1] == "now" {
+					fmt.Printf("Abort requested by %s, aborting\n", fields[2])
+				} else {
+					fmt.Printf("Abort requested by %s, stopping after the current node\n", fields[2])
+				}
+				continue
+			}
+
+			if strings.HasPrefix(msg, roll.IgnoredMsg+" ") {
+				fmt.Printf("Approval by %s ignored, a second operator must approve\n", strings.TrimPrefix(msg, roll.IgnoredMsg+" "))
+				continue
+			}
+
 			switch msg {
-			case "meta", "start", "success", "fail":
+			case "start":
+				fmt.Println("  -", msg)
+
+				if !quiet {
+					stop, done = make(chan struct{}), make(chan struct{})
+					go func(host string, attempt int, stop chan struct{}, done chan struct{}) {
+						defer close(done)
+						if err := roll.TailLogs(kv, roller.ID, host, attempt, stop, os.Stdout); err != nil {
+							fmt.Println("  - err: ", err)
+						}
+					}(host, attempt, stop, done)
+				}
+			case "success", "fail", "timeout":
+				stopTail()
+				fmt.Println("  -", msg)
+			case "retry":
+				attempt++
+				fmt.Println("  -", msg)
+			case "meta":
 				fmt.Println("  -", msg)
 			default:
+				stopTail()
+				if msg != host {
+					attempt = 1
+				}
+				host = msg
 				fmt.Printf("%s:\n", msg)
 			}
 		}
 	}()
 
-	fmt.Printf("Rolling (%v) nodes..\n", len(roller.Nodes))
+	fmt.Printf("Rolling (%v) nodes with action `%s` (id: %s)..\n", len(roller.Nodes), roller.Action, roller.ID)
+
+	err := roller.Roll(ctx)
+	printReport(roller.Report)
+
+	return err
+}
+
+// interruptible returns the context for a roll, the first interrupt stops
+// it after the current node and the second aborts it. cancel must be
+// called once the roll returns.
+func interruptible(roller *roll.Roll) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt)
+
+	go func() {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return
+		}
+
+		fmt.Println("Stopping after the current node, interrupt again to abort")
+		roller.Stop()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return
+		}
+
+		fmt.Println("Aborting")
+		cancel()
+	}()
+
+	return ctx, func() {
+		signal.Stop(ch)
+		cancel()
+	}
+}
+
+func cmRollback(c cli.Command) {
+	if len(c.Args()) != 1 {
+		fatalln("err: usage: rollback <roll id>")
+	}
+
+	if err := cmRunRollback(c, c.Arg(0).String()); err != nil {
+		fatal("Rollback err:", err)
+	}
+}
+
+// cmRunRollback re-rolls the nodes a roll converged, in reverse order,
+// with the revisions they had applied before it
+func cmRunRollback(c cli.Command, id string) error {
+	client := consulClient()
 
-	err = roller.Roll()
+	h, err := roll.GetHistory(client.KV(), id)
 	if err != nil {
-		roller.Destroy()
-		log.Fatal("Roll err:", err)
+		return err
+	}
+
+	if h == nil {
+		return errors.New("err: roll not found")
+	}
+
+	nodes := make([]string, 0)
+	for i := len(h.Report) - 1; i >= 0; i-- {
+		report := h.Report[i]
+		if report.Status != "success" {
+			continue
+		}
+
+		if h.Previous[report.Node] == "" {
+			fmt.Printf("%s: no previous revision recorded, skipping\n", report.Node)
+			continue
+		}
+
+		nodes = append(nodes, report.Node)
+	}
+
+	if len(nodes) == 0 {
+		return errors.New("err: nothing to roll back")
+	}
+
+	roller, err := roll.NewRoll(h.Role, c.Flag("override-freeze").Get() == true)
+	if err != nil {
+		return err
+	}
+	defer roller.Destroy()
+
+	roller.Nodes = nodes
+	roller.Action = h.Action
+	roller.Revisions = h.Previous
+	roller.RollbackOf = h.ID
+	roller.Retries = c.Flag("retries").Get().(int)
+	roller.RetryDelay = c.Flag("retry-delay").Get().(time.Duration)
+	roller.Timeout = c.Flag("timeout").Get().(time.Duration)
+	roller.WaitForWindow = c.Flag("wait-window").Get() == true
+
+	if roller.Previous, err = appliedRevisions(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Rolling back %s\n", h.ID)
+	return runRoll(c, roller)
+}
+
+func cmDecide(c cli.Command, decision string) {
+	if len(c.Args()) != 1 {
+		fatalln("err: usage: approve|reject <roll id>")
+	}
+
+	client := consulClient()
+	kv := client.KV()
+	id := c.Arg(0).String()
+
+	approval, _, err := roll.GetApproval(kv, id)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if err := roll.Decide(kv, id, roll.CurrentUser(), decision); err != nil {
+		fatalln(err)
+	}
+
+	fmt.Printf("roll %s: %s after %s\n", id, decision, approval.Stage)
+}
+
+func cmAbort(c cli.Command) {
+	client := consulClient()
+
+	abort := &roll.Abort{
+		User: roll.CurrentUser(),
+		Now:  c.Flag("now").Get() == true,
+		Time: time.Now(),
+	}
+
+	holder, err := roll.RequestAbort(client.KV(), abort)
+	if err != nil {
+		fatalln(err)
+	}
+
+	if abort.Now {
+		fmt.Printf("Aborting roll started by %s\n", holder)
+	} else {
+		fmt.Printf("Stopping roll started by %s after the current node\n", holder)
 	}
 }
+
+func cmStatus(c cli.Command) {
+	client := consulClient()
+	kv := client.KV()
+	follow := c.Flag("follow").Get() == true
+
+	// An interrupt ends following in the shell rather than the process
+	interrupt := make(chan os.Signal, 1)
+	if follow && inShell {
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+	}
+
+	var index uint64
+	var id string
+
+	for {
+		select {
+		case <-interrupt:
+			return
+		default:
+		}
+
+		status, meta, err := roll.GetStatus(kv, &api.QueryOptions{WaitIndex: index, WaitTime: 5 * time.Second})
+		if err != nil {
+			fatalln("err: ", err)
+		}
+
+		index = meta.LastIndex
+
+		if status == nil {
+			if id == "" {
+				fmt.Println("No roll in progress")
+				return
+			}
+
+			// the roll we were following finished
+			if h, err := roll.GetHistory(kv, id); err == nil && h != nil {
+				fmt.Printf("Roll %s finished: %s\n", id, h.Result)
+			}
+
+			return
+		}
+
+		history, err := roll.ListHistory(kv)
+		if err != nil {
+			fatalln("err: ", err)
+		}
+
+		id = status.ID
+		printStatus(status, history)
+
+		if !follow {
+			return
+		}
+
+		fmt.Println()
+	}
+}
+
+func printStatus(s *roll.Status, history []*roll.History) {
+	now := time.Now()
+
+	fmt.Printf("Roll %s by %s (role: %s, action: %s)\n", s.ID, s.User, s.Role, s.Action)
+
+	eta := "unknown"
+	if d, ok := s.ETA(history, now); ok {
+		eta = d.Round(time.Second).String()
+	}
+
+	fmt.Printf("  elapsed: %s, eta: %s\n", now.Sub(s.Started).Round(time.Second), eta)
+
+	if s.Paused != "" {
+		fmt.Printf("  paused after %s, waiting for approval\n", s.Paused)
+	}
+
+	for _, n := range s.Nodes {
+		switch {
+		case n.State == "running":
+			fmt.Printf("  - %s: running (%s)\n", n.Node, now.Sub(n.Started).Round(time.Second))
+		case n.Duration > 0:
+			fmt.Printf("  - %s: %s (%s)\n", n.Node, n.State, n.Duration.Round(time.Second))
+		default:
+			fmt.Printf("  - %s: %s\n", n.Node, n.State)
+		}
+	}
+}
+
+func cmDrift(c cli.Command) {
+	client := consulClient()
+
+	services, _, err := client.Catalog().Service("cascade", "", nil)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	records, err := agent.GetRunRecords(client.KV())
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-c.Flag("older-than").Get().(time.Duration))
+
+	drifted := make(map[string][]string)
+	for _, service := range services {
+		record := records[service.Node]
+
+		var reason string
+		switch {
+		case record == nil:
+			reason = "never reported"
+		case record.Outcome != "success":
+			reason = fmt.Sprintf("last %s %s %s ago", record.Action, record.Outcome, now.Sub(record.Time).Round(time.Second))
+		case record.LastSuccess.Before(cutoff):
+			reason = fmt.Sprintf("last converged %s ago", now.Sub(record.LastSuccess).Round(time.Second))
+		default:
+			continue
+		}
+
+		if record != nil && record.Outcome != "success" {
+			if record.LastSuccess.IsZero() {
+				reason += ", never converged"
+			} else {
+				reason += fmt.Sprintf(", last converged %s ago", now.Sub(record.LastSuccess).Round(time.Second))
+			}
+		}
+
+		if record != nil && record.Revision != "" {
+			reason += fmt.Sprintf(" (revision %s)", record.Revision)
+		}
+
+		roles := service.ServiceTags
+		if len(roles) == 0 {
+			roles = []string{"(none)"}
+		}
+
+		for _, role := range roles {
+			drifted[role] = append(drifted[role], fmt.Sprintf("%s: %s", service.Node, reason))
+		}
+	}
+
+	if len(drifted) == 0 {
+		fmt.Println("No drift")
+		return
+	}
+
+	roles := make([]string, 0)
+	for role := range drifted {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	for _, role := range roles {
+		fmt.Printf("%s:\n", role)
+
+		sort.Strings(drifted[role])
+		for _, line := range drifted[role] {
+			fmt.Println("  -", line)
+		}
+	}
+}
+
+// appliedRevisions returns the revision each node last applied
+func appliedRevisions() (map[string]string, error) {
+	client := consulClient()
+
+	records, err := agent.GetRunRecords(client.KV())
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]string)
+	for node, record := range records {
+		if record.Revision != "" {
+			applied[node] = record.Revision
+		}
+	}
+
+	return applied, nil
+}
+
+// outdatedNodes filters the roll to nodes with a pinned revision they
+// haven't applied
+func outdatedNodes(roller *roll.Roll, applied map[string]string) []string {
+	nodes := make([]string, 0)
+	for _, node := range roller.Nodes {
+		if pinned := roller.Revision(node); pinned != "" && applied[node] != pinned {
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes
+}
+
+func cmLogs(c cli.Command) {
+	if len(c.Args()) != 2 {
+		fatalln("err: usage: logs <roll id> <nodename>")
+	}
+
+	client := consulClient()
+
+	found, err := roll.Logs(client.KV(), c.Arg(0).String(), c.Arg(1).String(), os.Stdout)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if !found {
+		fatalln("no logs found")
+	}
+}
+
+func cmHistory(c cli.Command) {
+	client := consulClient()
+	kv := client.KV()
+
+	if len(c.Args()) > 0 {
+		h, err := roll.GetHistory(kv, c.Arg(0).String())
+		if err != nil {
+			fatalln("err: ", err)
+		}
+
+		if h == nil {
+			fatalln("roll not found")
+		}
+
+		printHistory(h)
+		printReport(h.Report)
+		return
+	}
+
+	history, err := roll.ListHistory(kv)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	for _, h := range history {
+		printHistory(h)
+	}
+}
+
+func printHistory(h *roll.History) {
+	fmt.Printf("%s:\n", h.ID)
+	fmt.Println("  user:", h.User)
+	fmt.Println("  role:", h.Role)
+	fmt.Println("  action:", h.Action)
+	fmt.Println("  nodes:", len(h.Nodes))
+	fmt.Println("  started:", h.Started.Format(time.RFC3339))
+	fmt.Println("  result:", h.Result)
+	if h.FreezeOverride != "" {
+		fmt.Println("  freeze override:", h.FreezeOverride)
+	}
+	if h.RollbackOf != "" {
+		fmt.Println("  rollback of:", h.RollbackOf)
+	}
+	if h.AbortedBy != "" {
+		fmt.Println("  aborted by:", h.AbortedBy)
+	}
+	for _, a := range h.Approvals {
+		if a.Decision == "" {
+			fmt.Printf("  gate: %s (pending)\n", a.Stage)
+		} else {
+			fmt.Printf("  gate: %s (%s by %s)\n", a.Stage, a.Decision, a.User)
+		}
+	}
+}
+
+func printReport(report []*roll.NodeReport) {
+	fmt.Println("Report:")
+	for _, r := range report {
+		fmt.Printf("  - %s: %s (attempts: %d, changes: %d, %s)\n", r.Node, r.Status, r.Attempts, r.Changes, r.Duration)
+	}
+}
+
+// paramFlag collects repeated key=value flags
+type paramFlag map[string]string
+
+func (p paramFlag) Get() interface{} {
+	return map[string]string(p)
+}
+
+func (p paramFlag) Set(val string) error {
+	// defaults are set from the empty string
+	if val == "" {
+		return nil
+	}
+
+	kv := strings.SplitN(val, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return errors.New(fmt.Sprintf("err: param must be key=value: %s", val))
+	}
+
+	p[kv[0]] = kv[1]
+	return nil
+}
+
+func (p paramFlag) String() string {
+	pairs := make([]string, 0)
+	for k, v := range p {
+		pairs = append(pairs, k+"="+v)
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,103 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/jwaldrip/odin/cli"
+)
+
+const Version = "0.0.1"
+
+// NewCLI returns the cascade command line with new instances of every
+// subcommand, as odin commands can only be started once
+func NewCLI(handling cli.ErrorHandling) *cli.CLI {
+	cascade := cli.New(Version, "cascade", cli.ShowUsage)
+	cascade.ErrorHandling = handling
+
+	cascade.DefineStringFlag("output", OutputText, "listing format: text, table, json or yaml")
+	cascade.SubCommandsInheritFlag("output")
+
+	cascade.AddSubCommands(commands()...)
+
+	return cascade
+}
+
+func commands() []*cli.SubCommand {
+	return []*cli.SubCommand{
+		newAgent(),
+		newCm(),
+		newCompletion(),
+		newExec(),
+		newFreeze(),
+		newNode(),
+		newPush(),
+		newRole(),
+		newService(),
+		newShell(),
+	}
+}
+
+var (
+	clientOnce   sync.Once
+	sharedClient *api.Client
+)
+
+// consulClient returns the Consul client shared by commands, so the shell
+// keeps one for its session
+func consulClient() *api.Client {
+	clientOnce.Do(func() {
+		sharedClient, _ = api.NewClient(api.DefaultConfig())
+	})
+
+	return sharedClient
+}
+
+// exited is the panic that ends a command run by the shell in place of
+// exiting the process
+type exited int
+
+// Set while the shell runs commands
+var inShell bool
+
+func exit(code int) {
+	if inShell {
+		panic(exited(code))
+	}
+
+	os.Exit(code)
+}
+
+func fatal(v ...interface{}) {
+	log.Print(v...)
+	exit(1)
+}
+
+func fatalf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	exit(1)
+}
+
+func fatalln(v ...interface{}) {
+	log.Println(v...)
+	exit(1)
+}
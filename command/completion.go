@@ -0,0 +1,335 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jwaldrip/odin/cli"
+
+	"github.com/boundary/cascade/roll"
+)
+
+func newCompletion() *cli.SubCommand {
+	cmd := cli.NewSubCommand("completion", "Shell completion scripts", completionRun, "shell")
+	cmd.SetLongDescription(`
+Print a completion script for bash, zsh or fish
+
+  source <(cascade completion bash)
+  cascade completion zsh > "${fpath[1]}/_cascade"
+  cascade completion fish > ~/.config/fish/completions/cascade.fish
+
+Node names, roles, services and roll ids are completed from Consul. The
+scripts call back into cascade with: completion complete -- <words>
+  `)
+
+	return cmd
+}
+
+var completionScripts = map[string]string{
+	"bash": `_cascade() {
+    local IFS=$'\n'
+    COMPREPLY=( $(cascade completion complete -- "${COMP_WORDS[@]:1:$COMP_CWORD}" 2>/dev/null) )
+}
+complete -o default -F _cascade cascade
+`,
+	"zsh": `#compdef cascade
+_cascade() {
+    local -a candidates
+    candidates=( ${(f)"$(cascade completion complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)"} )
+    (( ${#candidates} )) && compadd -- $candidates
+}
+compdef _cascade cascade
+`,
+	"fish": `function __cascade_complete
+    set -l words (commandline -opc)
+    set -e words[1]
+    cascade completion complete -- $words (commandline -ct) 2>/dev/null
+end
+complete -c cascade -f -a '(__cascade_complete)'
+`,
+}
+
+func completionRun(c cli.Command) {
+	shell := c.Param("shell").String()
+
+	// callback from the scripts, the last word is the one completed
+	if shell == "complete" {
+		words := c.Args().Strings()
+		if len(words) == 0 {
+			words = []string{""}
+		}
+
+		// only the names the word can be
+		names := make(catalogNames)
+		if kind := completionKind(words); kind != "" {
+			names[kind], _ = loadNames(kind)
+		}
+
+		for _, candidate := range complete(words, names) {
+			fmt.Println(candidate)
+		}
+		return
+	}
+
+	script, ok := completionScripts[shell]
+	if !ok {
+		cli.ShowUsage(c)
+		return
+	}
+
+	fmt.Print(script)
+}
+
+// What the arguments of an action name
+const (
+	completeNodes    = "nodes"
+	completeRoles    = "roles"
+	completeServices = "services"
+	completeRolls    = "rolls"
+)
+
+// Placeholders in the actions of subcommand usage and what they name
+var placeholderKinds = map[string]string{
+	"nodename":    completeNodes,
+	"role":        completeRoles,
+	"roles":       completeRoles,
+	"servicename": completeServices,
+	"roll id":     completeRolls,
+}
+
+var placeholder = regexp.MustCompile(`<([^>]+)>`)
+
+// action is an action of a subcommand and the kinds of its arguments
+type action struct {
+	name string
+	args []string
+}
+
+func subCommand(name string) *cli.SubCommand {
+	for _, cmd := range commands() {
+		if cmd.Name() == name {
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+func commandNames() []string {
+	names := make([]string, 0)
+	for _, cmd := range commands() {
+		names = append(names, cmd.Name())
+	}
+
+	return names
+}
+
+// commandActions reads the actions of a subcommand from the Actions
+// section of its usage, "  name <arg> [<arg>] - description"
+func commandActions(name string) []action {
+	actions := make([]action, 0)
+
+	if name == "completion" {
+		shells := make([]string, 0)
+		for shell := range completionScripts {
+			shells = append(shells, shell)
+		}
+
+		sort.Strings(shells)
+		for _, shell := range shells {
+			actions = append(actions, action{name: shell})
+		}
+
+		return actions
+	}
+
+	cmd := subCommand(name)
+	if cmd == nil {
+		return actions
+	}
+
+	seen := make(map[string]bool)
+	listed := false
+	for _, line := range strings.Split(cmd.LongDescription(), "\n") {
+		if line == "Actions:" {
+			listed = true
+			continue
+		}
+
+		if !listed {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "  ") {
+			break
+		}
+
+		usage := strings.SplitN(strings.TrimSpace(line), " - ", 2)[0]
+		fields := strings.Fields(usage)
+		if len(fields) == 0 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+
+		a := action{name: fields[0]}
+		for _, m := range placeholder.FindAllStringSubmatch(usage, -1) {
+			a.args = append(a.args, placeholderKinds[m[1]])
+		}
+
+		actions = append(actions, a)
+	}
+
+	return actions
+}
+
+func commandFlags(name string) []string {
+	flags := make([]string, 0)
+	if cmd := subCommand(name); cmd != nil {
+		for flag := range cmd.Flags() {
+			flags = append(flags, "--"+flag)
+		}
+	}
+
+	sort.Strings(flags)
+	return flags
+}
+
+// catalogNames are the completion candidates read from Consul by kind
+type catalogNames map[string][]string
+
+// loadNames reads the completion candidates of one kind from Consul
+func loadNames(kind string) ([]string, error) {
+	client := consulClient()
+	names := make([]string, 0)
+
+	switch kind {
+	case completeNodes:
+		nodes, _, err := client.Catalog().Nodes(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range nodes {
+			names = append(names, node.Node)
+		}
+	case completeServices, completeRoles:
+		services, _, err := client.Catalog().Services(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for service, tags := range services {
+			if kind == completeServices {
+				names = append(names, service)
+			} else if service == "cascade" {
+				// roles are the tags of the cascade service
+				names = append(names, tags...)
+			}
+		}
+	case completeRolls:
+		ids, err := roll.ListHistoryIDs(client.KV())
+		if err != nil {
+			return nil, err
+		}
+
+		names = ids
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// commandWords drops the global options before the subcommand, unless
+// completing the value of --output
+func commandWords(words []string) []string {
+	for len(words) > 1 && strings.HasPrefix(words[0], "-") && !(words[0] == "--output" && len(words) == 2) {
+		if words[0] == "--output" {
+			words = words[1:]
+		}
+
+		words = words[1:]
+	}
+
+	return words
+}
+
+// completionKind returns the kind of names the last of words can be, if
+// any, so only those need to be read from Consul
+func completionKind(words []string) string {
+	words = commandWords(words)
+
+	n := len(words) - 1
+	switch {
+	case strings.HasPrefix(words[n], "-"), n > 0 && words[n-1] == "--output":
+		return ""
+	case n > 0 && (words[n-1] == "-r" || words[n-1] == "--role"):
+		return completeRoles
+	case n >= 2:
+		for _, a := range commandActions(words[0]) {
+			if a.name == words[1] && n-2 < len(a.args) {
+				return a.args[n-2]
+			}
+		}
+	}
+
+	return ""
+}
+
+// complete returns the candidates for the last of words, which may be
+// empty when completing a new word
+func complete(words []string, names catalogNames) []string {
+	words = commandWords(words)
+
+	n := len(words) - 1
+	prefix := words[n]
+
+	var candidates []string
+	switch kind := completionKind(words); {
+	case kind != "":
+		candidates = names[kind]
+	case n > 0 && words[n-1] == "--output":
+		candidates = []string{OutputText, OutputTable, OutputJSON, OutputYAML}
+	case strings.HasPrefix(prefix, "-"):
+		if n == 0 {
+			candidates = []string{"--output", "--help", "--version"}
+		} else {
+			candidates = commandFlags(words[0])
+		}
+	case n == 0:
+		candidates = commandNames()
+	case n == 1:
+		for _, a := range commandActions(words[0]) {
+			candidates = append(candidates, a.name)
+		}
+	}
+
+	result := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) && !seen[c] {
+			seen[c] = true
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,92 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	names := catalogNames{
+		completeNodes: {"db1", "web1", "web2"},
+		completeRoles: {"db", "web"},
+		completeRolls: {"abc", "abd"},
+	}
+
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{""}, []string{"agent", "cm", "completion", "exec", "freeze", "node", "push", "role", "service", "shell"}},
+		{[]string{"no"}, []string{"node"}},
+		{[]string{"node", ""}, []string{"list", "info", "cordon", "uncordon"}},
+		{[]string{"node", "c"}, []string{"cordon"}},
+		{[]string{"node", "info", ""}, []string{"db1", "web1", "web2"}},
+		{[]string{"node", "info", "we"}, []string{"web1", "web2"}},
+		{[]string{"cm", "logs", "abd"}, []string{"abd"}},
+		{[]string{"cm", "roll", "-r", ""}, []string{"db", "web"}},
+		{[]string{"cm", "roll", "--role", "w"}, []string{"web"}},
+		{[]string{"--output", ""}, []string{"text", "table", "json", "yaml"}},
+		{[]string{"--output", "j"}, []string{"json"}},
+		{[]string{"--output", "json", "ro"}, []string{"role"}},
+		{[]string{"-"}, []string{"--output", "--help", "--version"}},
+		{[]string{"exec", "x", ""}, []string{}},
+		{[]string{"cm", "logs", "abc", "w"}, []string{"web1", "web2"}},
+		{[]string{"completion", ""}, []string{"bash", "fish", "zsh"}},
+	}
+
+	for _, test := range tests {
+		if got := complete(test.words, names); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("complete(%q) = %q, want %q", test.words, got, test.want)
+		}
+	}
+}
+
+func TestCompletionKind(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{""}, ""},
+		{[]string{"node", ""}, ""},
+		{[]string{"node", "info", ""}, completeNodes},
+		{[]string{"node", "info", "web1", ""}, ""},
+		{[]string{"cm", "logs", ""}, completeRolls},
+		{[]string{"cm", "logs", "abc", ""}, completeNodes},
+		{[]string{"cm", "roll", "-r", ""}, completeRoles},
+		{[]string{"role", "window", ""}, completeRoles},
+		{[]string{"role", "window", "web", ""}, ""},
+		{[]string{"service", "find", ""}, completeServices},
+		{[]string{"node", "info", "-"}, ""},
+		{[]string{"--output", "json", "node", "info", ""}, completeNodes},
+	}
+
+	for _, test := range tests {
+		if got := completionKind(test.words); got != test.want {
+			t.Errorf("completionKind(%q) = %q, want %q", test.words, got, test.want)
+		}
+	}
+}
+
+func TestCompleteFlags(t *testing.T) {
+	got := complete([]string{"cm", "--roll"}, catalogNames{})
+	if !reflect.DeepEqual(got, []string{"--rollback-on-failure"}) {
+		t.Errorf("complete(cm --roll) = %q", got)
+	}
+}
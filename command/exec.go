@@ -0,0 +1,198 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jwaldrip/odin/cli"
+
+	"github.com/boundary/cascade/agent"
+	"github.com/boundary/cascade/message"
+	"github.com/boundary/cascade/roll"
+)
+
+func newExec() *cli.SubCommand {
+	cmd := cli.NewSubCommand("exec", "Run ad-hoc commands on nodes", execRun)
+	cmd.DefineStringFlag("role", "", "filter by role")
+	cmd.AliasFlag('r', "role")
+
+	cmd.DefineBoolFlag("force", false, "run even if no `role` filter is set")
+	cmd.AliasFlag('f', "force")
+
+	cmd.DefineBoolFlag("override-freeze", false, "start even if changes are frozen, recorded in history")
+	cmd.DefineBoolFlag("wait-window", false, "hold nodes outside their role's maintenance window until it opens")
+
+	cmd.DefineIntFlag("concurrency", 1, "number of nodes to run on at once")
+	cmd.AliasFlag('c', "concurrency")
+
+	cmd.DefineDurationFlag("timeout", message.DefaultTimeout, "time to wait for each node to reply")
+	cmd.DefineIntFlag("retries", 0, "resend to a node that didn't reply up to `retries` times")
+
+	cmd.SetLongDescription(`
+Run a command on member systems
+
+  cascade exec -r <role> -- <command>
+
+Nodes are selected, ordered and locked as for cm roll, and like it nodes
+outside their maintenance windows are refused unless --wait-window is
+set. The command is run
+with /bin/sh -c by agents started with --allow-exec, and nodes with
+identical output are grouped together.
+  `)
+
+	return cmd
+}
+
+type execResult struct {
+	nodes  []string
+	reply  *agent.ExecReply
+	errMsg string
+}
+
+func (r *execResult) key() string {
+	if r.errMsg != "" {
+		return "err\x00" + r.errMsg
+	}
+
+	return fmt.Sprintf("%d\x00%s\x00%s", r.reply.ExitCode, r.reply.Stdout, r.reply.Stderr)
+}
+
+func execRun(c cli.Command) {
+	command := strings.Join(c.Args().Strings(), " ")
+	if command == "" {
+		fatalln("err: missing command, usage: exec -r <role> -- <command>")
+	}
+
+	role := c.Flag("role").String()
+	if len(role) == 0 && c.Flag("force").Get() != true {
+		fatalln("Must specify -f option to run with no `role` filter specified")
+	}
+
+	roller, err := roll.NewRoll(role, c.Flag("override-freeze").Get() == true)
+	if err != nil {
+		fatalln("Err: ", err)
+	}
+
+	roller.Action = "exec"
+	roller.Concurrency = c.Flag("concurrency").Get().(int)
+	roller.WaitForWindow = c.Flag("wait-window").Get() == true
+
+	// Nothing to render, exec replies are collected below
+	go func() {
+		for range roller.Msg {
+		}
+	}()
+
+	client := message.NewClient()
+	client.Timeout = c.Flag("timeout").Get().(time.Duration)
+	client.Retries = c.Flag("retries").Get().(int)
+
+	var mu sync.Mutex
+	results := make(map[string]*execResult)
+
+	fmt.Printf("Executing on (%v) nodes..\n", len(roller.Nodes))
+
+	ctx, cancel := interruptible(roller)
+	err = roller.Each(ctx, func(ctx context.Context, node string, report *roll.NodeReport) error {
+		result := &execResult{nodes: []string{node}}
+
+		reply := &agent.ExecReply{}
+		if msg, err := client.Send(ctx, node, agent.ExecMessage, &agent.ExecRequest{Command: command}); ctx.Err() != nil {
+			return roll.ErrRollAborted
+		} else if err != nil {
+			result.errMsg = err.Error()
+		} else if err := msg.Decode(reply); err != nil {
+			result.errMsg = err.Error()
+		} else {
+			result.reply = reply
+		}
+
+		mu.Lock()
+		results[node] = result
+		mu.Unlock()
+
+		return nil
+	})
+	cancel()
+
+	roller.Destroy()
+
+	if err != nil {
+		fatalln("Err: ", err)
+	}
+
+	if !printExecResults(roller.Nodes, results) {
+		exit(1)
+	}
+}
+
+// printExecResults shows nodes grouped by identical output in roll order,
+// returning false if any node failed
+func printExecResults(nodes []string, results map[string]*execResult) bool {
+	ok := true
+	groups := make([]*execResult, 0)
+	index := make(map[string]*execResult)
+
+	for _, node := range nodes {
+		result := results[node]
+		if result == nil {
+			continue
+		}
+
+		if result.errMsg != "" || result.reply.ExitCode != 0 {
+			ok = false
+		}
+
+		if group, seen := index[result.key()]; seen {
+			group.nodes = append(group.nodes, node)
+		} else {
+			index[result.key()] = result
+			groups = append(groups, result)
+		}
+	}
+
+	for _, group := range groups {
+		if group.errMsg != "" {
+			fmt.Printf("%s (%s):\n", strings.Join(group.nodes, ", "), group.errMsg)
+			continue
+		}
+
+		fmt.Printf("%s (exit %d):\n", strings.Join(group.nodes, ", "), group.reply.ExitCode)
+		printIndented(group.reply.Stdout, "  ")
+
+		if group.reply.Stderr != "" {
+			fmt.Println("  stderr:")
+			printIndented(group.reply.Stderr, "    ")
+		}
+	}
+
+	return ok
+}
+
+func printIndented(s string, indent string) {
+	for _, line := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		if line != "" {
+			fmt.Println(indent + line)
+		}
+	}
+}
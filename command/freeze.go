@@ -0,0 +1,179 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/jwaldrip/odin/cli"
+
+	"github.com/boundary/cascade/roll"
+)
+
+func newFreeze() *cli.SubCommand {
+	cmd := cli.NewSubCommand("freeze", "Change freeze operations", freezeRun)
+	cmd.DefineParams("action")
+	cmd.DefineStringFlag("reason", "", "reason for the freeze")
+
+	cmd.DefineStringFlag("start", "", "window start (RFC3339)")
+	cmd.DefineStringFlag("end", "", "window end (RFC3339)")
+	cmd.DefineStringFlag("repeat", "", "repeat the window daily or weekly")
+	cmd.DefineStringFlag("timezone", "", "timezone repeats keep their wall clock time in (e.g. Europe/London)")
+
+	cmd.SetLongDescription(`
+Stop rolls from starting, cm roll --override-freeze bypasses a freeze
+
+Actions:
+  on - freeze changes now
+  off - lift the manual freeze, scheduled windows still apply
+  status - show the freeze and scheduled windows
+  schedule - add a window with --start, --end and optionally --repeat
+  unschedule <n> - remove the nth scheduled window
+
+Repeating windows keep the wall clock time of --start in --timezone, so
+they follow daylight saving changes. Without it they keep the UTC offset
+of --start.
+  `)
+
+	return cmd
+}
+
+func freezeRun(c cli.Command) {
+	switch c.Param("action").String() {
+	case "on":
+		freezeOn(c)
+	case "off":
+		freezeOff(c)
+	case "status":
+		freezeStatus(c)
+	case "schedule":
+		freezeSchedule(c)
+	case "unschedule":
+		freezeUnschedule(c)
+	default:
+		cli.ShowUsage(c)
+	}
+}
+
+func getFreeze() (*api.KV, *roll.Freeze) {
+	client := consulClient()
+	kv := client.KV()
+
+	freeze, err := roll.GetFreeze(kv)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	return kv, freeze
+}
+
+func putFreeze(kv *api.KV, freeze *roll.Freeze) {
+	if err := roll.PutFreeze(kv, freeze); err != nil {
+		fatalln("err: ", err)
+	}
+}
+
+func freezeOn(c cli.Command) {
+	if c.Flag("reason").String() == "" {
+		fatalln("Must specify a --reason to freeze")
+	}
+
+	kv, freeze := getFreeze()
+
+	freeze.Active = true
+	freeze.Reason = c.Flag("reason").String()
+	freeze.User = roll.CurrentUser()
+	freeze.Time = time.Now()
+
+	putFreeze(kv, freeze)
+	fmt.Println("Changes frozen:", freeze.Reason)
+}
+
+func freezeOff(c cli.Command) {
+	kv, freeze := getFreeze()
+
+	freeze.Active = false
+	freeze.Reason = ""
+	freeze.User = roll.CurrentUser()
+	freeze.Time = time.Now()
+
+	putFreeze(kv, freeze)
+	fmt.Println("Freeze lifted")
+}
+
+func freezeStatus(c cli.Command) {
+	_, freeze := getFreeze()
+
+	if frozen := freeze.Frozen(time.Now()); frozen != "" {
+		fmt.Println("Changes are", frozen)
+	} else {
+		fmt.Println("Changes are not frozen")
+	}
+
+	if len(freeze.Windows) > 0 {
+		fmt.Println("Scheduled windows:")
+		for i, w := range freeze.Windows {
+			fmt.Printf("  %d - %s\n", i, w)
+		}
+	}
+}
+
+func freezeSchedule(c cli.Command) {
+	start, err := time.Parse(time.RFC3339, c.Flag("start").String())
+	if err != nil {
+		fatalln("err: invalid --start: ", err)
+	}
+
+	end, err := time.Parse(time.RFC3339, c.Flag("end").String())
+	if err != nil {
+		fatalln("err: invalid --end: ", err)
+	}
+
+	window, err := roll.NewFreezeWindow(start, end, c.Flag("repeat").String(), c.Flag("timezone").String(), c.Flag("reason").String())
+	if err != nil {
+		fatalln(err)
+	}
+
+	kv, freeze := getFreeze()
+	freeze.Windows = append(freeze.Windows, window)
+
+	putFreeze(kv, freeze)
+	fmt.Println("Scheduled freeze", window)
+}
+
+func freezeUnschedule(c cli.Command) {
+	if len(c.Args()) == 0 {
+		fatalln("err: missing <n> argument")
+	}
+
+	kv, freeze := getFreeze()
+
+	n, err := strconv.Atoi(c.Arg(0).String())
+	if err != nil || n < 0 || n >= len(freeze.Windows) {
+		fatalln("err: no such window: ", c.Arg(0).String())
+	}
+
+	window := freeze.Windows[n]
+	freeze.Windows = append(freeze.Windows[:n], freeze.Windows[n+1:]...)
+
+	putFreeze(kv, freeze)
+	fmt.Println("Removed freeze", window)
+}
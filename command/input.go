@@ -0,0 +1,148 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"unicode/utf8"
+)
+
+// input is the single reader of stdin, so prompts that give up waiting,
+// like a roll gate decided elsewhere, don't keep reading and take input
+// meant for whatever reads next. At most one read is outstanding, its
+// data goes to the next reader.
+type input struct {
+	file   *os.File
+	once   sync.Once
+	chunks chan []byte
+	buf    []byte
+}
+
+var stdin = &input{file: os.Stdin}
+
+func (in *input) start() {
+	in.chunks = make(chan []byte)
+
+	go func() {
+		for {
+			b := make([]byte, 256)
+			n, err := in.file.Read(b)
+			if n > 0 {
+				in.chunks <- b[:n]
+			}
+
+			if err != nil {
+				close(in.chunks)
+				return
+			}
+		}
+	}()
+}
+
+// fill waits for more input, returning false at EOF or once stop is closed
+func (in *input) fill(stop <-chan struct{}) bool {
+	in.once.Do(in.start)
+
+	select {
+	case b, ok := <-in.chunks:
+		if !ok {
+			return false
+		}
+
+		in.buf = append(in.buf, b...)
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// readLine returns the next line without its newline
+func (in *input) readLine(stop <-chan struct{}) (string, bool) {
+	for {
+		if i := bytes.IndexByte(in.buf, '\n'); i >= 0 {
+			line := string(bytes.TrimRight(in.buf[:i], "\r"))
+			in.buf = in.buf[i+1:]
+			return line, true
+		}
+
+		if !in.fill(stop) {
+			return "", false
+		}
+	}
+}
+
+// readRune returns the next UTF-8 encoded rune
+func (in *input) readRune() (rune, error) {
+	for !utf8.FullRune(in.buf) {
+		if !in.fill(nil) {
+			if len(in.buf) > 0 {
+				// truncated sequence at EOF
+				in.buf = in.buf[1:]
+				return utf8.RuneError, nil
+			}
+
+			return 0, io.EOF
+		}
+	}
+
+	r, size := utf8.DecodeRune(in.buf)
+	in.buf = in.buf[size:]
+	return r, nil
+}
+
+// prompt reads lines for fn until stopped, fn returns false to stop early
+type prompt struct {
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (p *prompt) start(fn func(line string) bool) {
+	p.end()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	p.stop, p.done = stop, done
+
+	go func() {
+		defer close(done)
+		for {
+			line, ok := stdin.readLine(stop)
+			if !ok || !fn(line) {
+				return
+			}
+		}
+	}()
+}
+
+// end stops reading and waits for the reader to finish
+func (p *prompt) end() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		<-p.done
+		p.stop = nil
+	}
+}
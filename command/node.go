@@ -19,50 +19,488 @@ package command
 
 import (
 	"fmt"
-	"log"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/jwaldrip/odin/cli"
+
+	"github.com/boundary/cascade/agent"
+	"github.com/boundary/cascade/roll"
 )
 
-var Node = cli.NewSubCommand("node", "Node operations", nodeRun)
+// Check registered by consul maint -enable
+const nodeMaintenanceCheck = "_node_maintenance"
+
+func newNode() *cli.SubCommand {
+	cmd := cli.NewSubCommand("node", "Node operations", nodeRun)
+	cmd.DefineParams("action")
+	cmd.DefineStringFlag("role", "", "filter by role")
+	cmd.AliasFlag('r', "role")
 
-func init() {
-	Node.DefineParams("action")
-	Node.DefineStringFlag("role", "", "filter by role")
-	Node.AliasFlag('r', "role")
+	cmd.DefineStringFlag("reason", "", "reason for cordoning a node")
 
-	Node.SetLongDescription(`
+	cmd.SetLongDescription(`
 Interact with cascade nodes
 
 Actions:
   list - list nodes
+  info <nodename> - show facts, services, health checks, last converge and state of a node
+  cordon <nodename> - exclude node from rolls
+  uncordon <nodename> - include cordoned node in rolls again
+
+With cascade --output json|yaml, list prints a list of
+  {node, address, roles: [], cordon: {reason, user, time} | null}
+
+and info prints
+  {node, address, tagged_addresses: {}, roles: [],
+   facts: {hostname, os, arch, kernel, cpus, backend, time} | null,
+   services: [{service, node, address, port, tags: []}],
+   checks: [{check, name, service, status, output}],
+   last_converge: <run> | null, last_run: <run> | null,
+   cordon: {reason, user, time} | null, maintenance: {reason} | null,
+   next_eligible, coordinate: {vec: [], error, adjustment, height, rtt} | null}
+
+where <run> is {time, action, outcome, duration, changes, revision,
+source, last_success}.
+
+Facts are published by the node's agent when it starts, the Consul API
+cascade is built with has no node meta. last_converge is the last run of
+the run action, last_run that of any action such as why-run.
+next_eligible is empty if the node's maintenance windows never open
+together, and rtt is estimated from the local node.
   `)
+
+	return cmd
 }
 
 func nodeRun(c cli.Command) {
 	switch c.Param("action").String() {
 	case "list":
 		nodeList(c)
+	case "info":
+		nodeInfo(c)
+	case "cordon":
+		nodeCordon(c)
+	case "uncordon":
+		nodeUncordon(c)
 	default:
 		cli.ShowUsage(c)
 	}
 }
 
 func nodeList(c cli.Command) {
-	client, _ := api.NewClient(api.DefaultConfig())
+	client := consulClient()
 	catalog := client.Catalog()
 
 	nodes, _, err := catalog.Service("cascade", c.Flag("role").String(), nil)
 
 	if err != nil {
-		log.Fatalln("Err: ", err)
+		fatalln("Err: ", err)
 	}
 
+	cordons, err := roll.GetCordons(client.KV())
+	if err != nil {
+		fatalln("Err: ", err)
+	}
+
+	value := make([]*NodeOutput, 0)
+	rows := make([][]string, 0)
+
 	for _, node := range nodes {
-		fmt.Println(node.Node, node.Address+":")
-		for _, role := range node.ServiceTags {
-			fmt.Println("  -", role)
+		out := &NodeOutput{Node: node.Node, Address: node.Address, Roles: stringList(node.ServiceTags)}
+		cordoned := ""
+
+		if cordon := cordons[node.Node]; cordon != nil {
+			out.Cordon = &CordonOutput{cordon.Reason, cordon.User, cordon.Time.Format(time.RFC3339)}
+			cordoned = cordon.String()
+		}
+
+		value = append(value, out)
+		rows = append(rows, []string{node.Node, node.Address, strings.Join(node.ServiceTags, ","), cordoned})
+	}
+
+	l := &listing{value: value, header: []string{"NODE", "ADDRESS", "ROLES", "CORDON"}, rows: rows}
+	l.text = func() {
+		for _, node := range nodes {
+			if cordon := cordons[node.Node]; cordon != nil {
+				fmt.Printf("%s %s: (%s)\n", node.Node, node.Address, cordon)
+			} else {
+				fmt.Println(node.Node, node.Address+":")
+			}
+			for _, role := range node.ServiceTags {
+				fmt.Println("  -", role)
+			}
+		}
+	}
+
+	l.print(c)
+}
+
+func nodeInfo(c cli.Command) {
+	if len(c.Args()) == 0 {
+		fatalln("err: missing <nodename> argument")
+	}
+
+	client := consulClient()
+	kv := client.KV()
+	name := c.Arg(0).String()
+
+	catalogNode, _, err := client.Catalog().Node(name, nil)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if catalogNode == nil {
+		fatalln("node not found")
+	}
+
+	out := &NodeInfoOutput{
+		Node:            catalogNode.Node.Node,
+		Address:         catalogNode.Node.Address,
+		TaggedAddresses: make(map[string]string),
+		Roles:           make([]string, 0),
+		Services:        make([]*ServiceInstanceOutput, 0),
+		Checks:          make([]*CheckOutput, 0),
+	}
+
+	for k, v := range catalogNode.Node.TaggedAddresses {
+		out.TaggedAddresses[k] = v
+	}
+
+	for _, service := range catalogNode.Services {
+		if service.Service == "cascade" {
+			out.Roles = stringList(service.Tags)
+		}
+
+		address := service.Address
+		if address == "" {
+			address = out.Address
+		}
+
+		out.Services = append(out.Services, &ServiceInstanceOutput{service.Service, name, address, service.Port, stringList(service.Tags)})
+	}
+	sort.Sort(byService(out.Services))
+
+	checks, _, err := client.Health().Node(name, nil)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	for _, check := range checks {
+		if check.CheckID == nodeMaintenanceCheck {
+			out.Maintenance = &MaintenanceOutput{check.Notes}
+		}
+
+		out.Checks = append(out.Checks, &CheckOutput{check.CheckID, check.Name, check.ServiceName, check.Status, strings.TrimSpace(check.Output)})
+	}
+	sort.Sort(byCheck(out.Checks))
+
+	facts, err := agent.GetFacts(kv, name)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if facts != nil {
+		out.Facts = &FactsOutput{facts.Hostname, facts.OS, facts.Arch, facts.Kernel, facts.CPUs, facts.Backend, facts.Time.Format(time.RFC3339)}
+	}
+
+	converge, err := agent.GetConvergeRecord(kv, name)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+	out.LastConverge = runOutput(converge)
+
+	run, err := agent.GetRunRecord(kv, name)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+	out.LastRun = runOutput(run)
+
+	cordons, err := roll.GetCordons(kv)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if cordon := cordons[name]; cordon != nil {
+		out.Cordon = &CordonOutput{cordon.Reason, cordon.User, cordon.Time.Format(time.RFC3339)}
+	}
+
+	schedule, err := roll.LoadSchedule(client)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	now := time.Now()
+	if next := schedule.NextEligible(name, now); !next.IsZero() {
+		out.NextEligible = next.Format(time.RFC3339)
+	}
+
+	out.Coordinate, err = nodeCoordinate(client, name)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	l := &listing{value: out, header: []string{"FIELD", "VALUE"}, rows: nodeInfoRows(out)}
+	l.text = func() { printNodeInfo(out, now) }
+	l.print(c)
+}
+
+func runOutput(record *agent.RunRecord) *RunOutput {
+	if record == nil {
+		return nil
+	}
+
+	out := &RunOutput{
+		Time:     record.Time.Format(time.RFC3339),
+		Action:   record.Action,
+		Outcome:  record.Outcome,
+		Duration: record.Duration.String(),
+		Changes:  record.Changes,
+		Revision: record.Revision,
+		Source:   record.Source,
+	}
+
+	if !record.LastSuccess.IsZero() {
+		out.LastSuccess = record.LastSuccess.Format(time.RFC3339)
+	}
+
+	return out
+}
+
+// nodeCoordinate returns the network coordinate of a node with the round
+// trip time estimated from the local node, nil if it has none yet
+func nodeCoordinate(client *api.Client, name string) (*CoordinateOutput, error) {
+	entries, _, err := client.Coordinate().Nodes(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	self, _, err := selfNode()
+	if err != nil {
+		return nil, err
+	}
+
+	var out *CoordinateOutput
+	for _, entry := range entries {
+		if entry.Node == name && entry.Coord != nil {
+			out = &CoordinateOutput{entry.Coord.Vec, entry.Coord.Error, entry.Coord.Adjustment, entry.Coord.Height, ""}
+		}
+	}
+
+	if out == nil {
+		return nil, nil
+	}
+
+	for _, entry := range entries {
+		if entry.Node != self || entry.Coord == nil {
+			continue
 		}
+
+		for _, other := range entries {
+			if other.Node == name && entry.Coord.IsCompatibleWith(other.Coord) {
+				out.RTT = entry.Coord.DistanceTo(other.Coord).String()
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func nodeInfoRows(out *NodeInfoOutput) [][]string {
+	services := make([]string, 0)
+	for _, service := range out.Services {
+		services = append(services, fmt.Sprintf("%s:%d", service.Service, service.Port))
+	}
+
+	checks := make([]string, 0)
+	for _, check := range out.Checks {
+		checks = append(checks, fmt.Sprintf("%s=%s", check.Check, check.Status))
+	}
+
+	rows := [][]string{
+		{"node", out.Node},
+		{"address", out.Address},
+		{"roles", strings.Join(out.Roles, ",")},
+		{"services", strings.Join(services, ",")},
+		{"checks", strings.Join(checks, ",")},
+	}
+
+	if out.Facts != nil {
+		rows = append(rows, []string{"facts", fmt.Sprintf("%s %s/%s %s, %d cpus, %s", out.Facts.Hostname, out.Facts.OS, out.Facts.Arch, out.Facts.Kernel, out.Facts.CPUs, out.Facts.Backend)})
+	}
+
+	if out.LastConverge != nil {
+		rows = append(rows, []string{"last_converge", fmt.Sprintf("%s at %s", out.LastConverge.Outcome, out.LastConverge.Time)})
+	}
+
+	if out.LastRun != nil {
+		rows = append(rows, []string{"last_run", fmt.Sprintf("%s %s at %s", out.LastRun.Action, out.LastRun.Outcome, out.LastRun.Time)})
 	}
+
+	if out.Cordon != nil {
+		rows = append(rows, []string{"cordon", out.Cordon.Reason})
+	}
+
+	if out.Maintenance != nil {
+		rows = append(rows, []string{"maintenance", out.Maintenance.Reason})
+	}
+
+	rows = append(rows, []string{"next_eligible", out.NextEligible})
+
+	if out.Coordinate != nil {
+		rows = append(rows, []string{"rtt", out.Coordinate.RTT})
+	}
+
+	return rows
+}
+
+func printNodeInfo(out *NodeInfoOutput, now time.Time) {
+	fmt.Println(out.Node, out.Address+":")
+
+	keys := make([]string, 0)
+	for k := range out.TaggedAddresses {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Printf("  %s address: %s\n", k, out.TaggedAddresses[k])
+	}
+
+	fmt.Println("\nRoles:")
+	for _, role := range out.Roles {
+		fmt.Println("  -", role)
+	}
+
+	fmt.Println("\nServices:")
+	for _, service := range out.Services {
+		fmt.Printf("  - %s %s:%d", service.Service, service.Address, service.Port)
+		if len(service.Tags) > 0 {
+			fmt.Printf(" [%s]", strings.Join(service.Tags, ", "))
+		}
+		fmt.Println()
+	}
+
+	fmt.Println("\nChecks:")
+	for _, check := range out.Checks {
+		if check.Service != "" {
+			fmt.Printf("  - %s (%s): %s\n", check.Name, check.Service, check.Status)
+		} else {
+			fmt.Printf("  - %s: %s\n", check.Name, check.Status)
+		}
+		printIndented(check.Output, "      ")
+	}
+
+	fmt.Println("\nFacts:")
+	if facts := out.Facts; facts != nil {
+		fmt.Printf("  %s, %s/%s, kernel %s, %d cpus, %s backend (as of %s)\n", facts.Hostname, facts.OS, facts.Arch, facts.Kernel, facts.CPUs, facts.Backend, facts.Time)
+	} else {
+		fmt.Println("  none published, agent not running or older")
+	}
+
+	fmt.Println("\nLast converge:")
+	if run := out.LastConverge; run != nil {
+		fmt.Printf("  %s at %s (%s via %s, %d changes)\n", run.Outcome, run.Time, run.Duration, run.Source, run.Changes)
+		if run.Revision != "" {
+			fmt.Println("  revision:", run.Revision)
+		}
+		if run.Outcome != "success" {
+			if run.LastSuccess == "" {
+				fmt.Println("  never converged")
+			} else {
+				fmt.Println("  last converged:", run.LastSuccess)
+			}
+		}
+	} else {
+		fmt.Println("  never reported")
+	}
+
+	// other actions since, like why-run
+	if run := out.LastRun; run != nil && run.Action != roll.DefaultAction {
+		fmt.Println("\nLast run:")
+		fmt.Printf("  %s %s at %s (%s via %s, %d changes)\n", run.Action, run.Outcome, run.Time, run.Duration, run.Source, run.Changes)
+	}
+
+	fmt.Println("\nState:")
+	if out.Cordon != nil {
+		fmt.Printf("  cordoned by %s at %s: %s\n", out.Cordon.User, out.Cordon.Time, out.Cordon.Reason)
+	} else {
+		fmt.Println("  not cordoned")
+	}
+
+	if out.Maintenance != nil {
+		fmt.Println("  in maintenance mode:", out.Maintenance.Reason)
+	}
+
+	switch next, _ := time.Parse(time.RFC3339, out.NextEligible); {
+	case out.NextEligible == "":
+		fmt.Println("  maintenance windows never open")
+	case next.After(now):
+		fmt.Println("  outside maintenance windows until", out.NextEligible)
+	default:
+		fmt.Println("  eligible to roll now")
+	}
+
+	fmt.Println("\nNetwork coordinate:")
+	if coord := out.Coordinate; coord != nil {
+		fmt.Printf("  vec %v error %.3f adjustment %.6f height %.6f\n", coord.Vec, coord.Error, coord.Adjustment, coord.Height)
+		if coord.RTT != "" {
+			fmt.Println("  estimated rtt from local node:", coord.RTT)
+		}
+	} else {
+		fmt.Println("  none")
+	}
+}
+
+type byService []*ServiceInstanceOutput
+
+func (s byService) Len() int           { return len(s) }
+func (s byService) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byService) Less(i, j int) bool { return s[i].Service < s[j].Service }
+
+type byCheck []*CheckOutput
+
+func (s byCheck) Len() int           { return len(s) }
+func (s byCheck) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCheck) Less(i, j int) bool { return s[i].Check < s[j].Check }
+
+func nodeCordon(c cli.Command) {
+	if len(c.Args()) == 0 {
+		fatalln("err: missing <nodename> argument")
+	}
+
+	client := consulClient()
+	node := c.Arg(0).String()
+
+	catalogNode, _, err := client.Catalog().Node(node, nil)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if catalogNode == nil {
+		fatalln("node not found")
+	}
+
+	if err := roll.SetCordon(client.KV(), node, c.Flag("reason").String()); err != nil {
+		fatalln("err: ", err)
+	}
+
+	fmt.Println("cordoned", node)
+}
+
+func nodeUncordon(c cli.Command) {
+	if len(c.Args()) == 0 {
+		fatalln("err: missing <nodename> argument")
+	}
+
+	client := consulClient()
+	node := c.Arg(0).String()
+
+	if err := roll.RemoveCordon(client.KV(), node); err != nil {
+		fatalln("err: ", err)
+	}
+
+	fmt.Println("uncordoned", node)
 }
@@ -0,0 +1,197 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jwaldrip/odin/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats selected with the global --output flag
+const (
+	OutputText  = "text"
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// Documented output schemas, fields are only ever added. Times are
+// RFC3339 strings.
+
+type NodeOutput struct {
+	Node    string        `json:"node" yaml:"node"`
+	Address string        `json:"address" yaml:"address"`
+	Roles   []string      `json:"roles" yaml:"roles"`
+	Cordon  *CordonOutput `json:"cordon" yaml:"cordon"`
+}
+
+type CordonOutput struct {
+	Reason string `json:"reason" yaml:"reason"`
+	User   string `json:"user" yaml:"user"`
+	Time   string `json:"time" yaml:"time"`
+}
+
+type RoleOutput struct {
+	Node    string   `json:"node" yaml:"node"`
+	Address string   `json:"address" yaml:"address"`
+	Roles   []string `json:"roles" yaml:"roles"`
+}
+
+type ServiceOutput struct {
+	Service string   `json:"service" yaml:"service"`
+	Tags    []string `json:"tags" yaml:"tags"`
+}
+
+type ServiceInstanceOutput struct {
+	Service string   `json:"service" yaml:"service"`
+	Node    string   `json:"node" yaml:"node"`
+	Address string   `json:"address" yaml:"address"`
+	Port    int      `json:"port" yaml:"port"`
+	Tags    []string `json:"tags" yaml:"tags"`
+}
+
+type NodeInfoOutput struct {
+	Node            string                   `json:"node" yaml:"node"`
+	Address         string                   `json:"address" yaml:"address"`
+	TaggedAddresses map[string]string        `json:"tagged_addresses" yaml:"tagged_addresses"`
+	Roles           []string                 `json:"roles" yaml:"roles"`
+	Services        []*ServiceInstanceOutput `json:"services" yaml:"services"`
+	Checks          []*CheckOutput           `json:"checks" yaml:"checks"`
+	Facts           *FactsOutput             `json:"facts" yaml:"facts"`
+	LastConverge    *RunOutput               `json:"last_converge" yaml:"last_converge"`
+	LastRun         *RunOutput               `json:"last_run" yaml:"last_run"`
+	Cordon          *CordonOutput            `json:"cordon" yaml:"cordon"`
+	Maintenance     *MaintenanceOutput       `json:"maintenance" yaml:"maintenance"`
+	NextEligible    string                   `json:"next_eligible" yaml:"next_eligible"`
+	Coordinate      *CoordinateOutput        `json:"coordinate" yaml:"coordinate"`
+}
+
+type FactsOutput struct {
+	Hostname string `json:"hostname" yaml:"hostname"`
+	OS       string `json:"os" yaml:"os"`
+	Arch     string `json:"arch" yaml:"arch"`
+	Kernel   string `json:"kernel" yaml:"kernel"`
+	CPUs     int    `json:"cpus" yaml:"cpus"`
+	Backend  string `json:"backend" yaml:"backend"`
+	Time     string `json:"time" yaml:"time"`
+}
+
+type CheckOutput struct {
+	Check   string `json:"check" yaml:"check"`
+	Name    string `json:"name" yaml:"name"`
+	Service string `json:"service" yaml:"service"`
+	Status  string `json:"status" yaml:"status"`
+	Output  string `json:"output" yaml:"output"`
+}
+
+type RunOutput struct {
+	Time        string `json:"time" yaml:"time"`
+	Action      string `json:"action" yaml:"action"`
+	Outcome     string `json:"outcome" yaml:"outcome"`
+	Duration    string `json:"duration" yaml:"duration"`
+	Changes     int    `json:"changes" yaml:"changes"`
+	Revision    string `json:"revision" yaml:"revision"`
+	Source      string `json:"source" yaml:"source"`
+	LastSuccess string `json:"last_success" yaml:"last_success"`
+}
+
+type MaintenanceOutput struct {
+	Reason string `json:"reason" yaml:"reason"`
+}
+
+type CoordinateOutput struct {
+	Vec        []float64 `json:"vec" yaml:"vec"`
+	Error      float64   `json:"error" yaml:"error"`
+	Adjustment float64   `json:"adjustment" yaml:"adjustment"`
+	Height     float64   `json:"height" yaml:"height"`
+	RTT        string    `json:"rtt" yaml:"rtt"`
+}
+
+// listing is what a listing command prints: value for json and yaml,
+// header and rows for table, text for the human readable default
+type listing struct {
+	value  interface{}
+	header []string
+	rows   [][]string
+	text   func()
+}
+
+func roleListing(entries []*RoleOutput, text func()) *listing {
+	rows := make([][]string, 0)
+	for _, e := range entries {
+		rows = append(rows, []string{e.Node, e.Address, strings.Join(e.Roles, ",")})
+	}
+
+	return &listing{value: entries, header: []string{"NODE", "ADDRESS", "ROLES"}, rows: rows, text: text}
+}
+
+// stringList returns l, empty rather than nil so lists are never
+// printed as null
+func stringList(l []string) []string {
+	if l == nil {
+		return make([]string, 0)
+	}
+
+	return l
+}
+
+func outputFormat(c cli.Command) string {
+	format := c.Flag("output").String()
+
+	switch format {
+	case OutputText, OutputTable, OutputJSON, OutputYAML:
+		return format
+	}
+
+	fatalln("err: --output must be one of text, table, json or yaml: ", format)
+	return ""
+}
+
+func (l *listing) print(c cli.Command) {
+	switch outputFormat(c) {
+	case OutputJSON:
+		out, err := json.MarshalIndent(l.value, "", "  ")
+		if err != nil {
+			fatalln("err: ", err)
+		}
+
+		fmt.Println(string(out))
+	case OutputYAML:
+		out, err := yaml.Marshal(l.value)
+		if err != nil {
+			fatalln("err: ", err)
+		}
+
+		fmt.Print(string(out))
+	case OutputTable:
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(l.header, "\t"))
+		for _, row := range l.rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+	default:
+		l.text()
+	}
+}
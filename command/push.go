@@ -0,0 +1,155 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jwaldrip/odin/cli"
+
+	"github.com/boundary/cascade/agent"
+	"github.com/boundary/cascade/message"
+	"github.com/boundary/cascade/roll"
+)
+
+func newPush() *cli.SubCommand {
+	cmd := cli.NewSubCommand("push", "Distribute a file to nodes", pushRun, "file", "path")
+	cmd.DefineStringFlag("role", "", "filter by role")
+	cmd.AliasFlag('r', "role")
+
+	cmd.DefineBoolFlag("force", false, "push even if no `role` filter is set")
+	cmd.AliasFlag('f', "force")
+
+	cmd.DefineStringFlag("mode", "0644", "octal file mode")
+	cmd.AliasFlag('m', "mode")
+
+	cmd.DefineStringFlag("owner", "", "user[:group] to own the file")
+	cmd.AliasFlag('o', "owner")
+
+	cmd.DefineBoolFlag("override-freeze", false, "start even if changes are frozen, recorded in history")
+	cmd.DefineBoolFlag("wait-window", false, "hold nodes outside their role's maintenance window until it opens")
+
+	cmd.DefineIntFlag("concurrency", 1, "number of nodes to push to at once")
+	cmd.AliasFlag('c', "concurrency")
+
+	cmd.DefineDurationFlag("timeout", message.DefaultTimeout, "time to wait for each node to reply")
+	cmd.DefineIntFlag("retries", 0, "resend to a node that didn't reply up to `retries` times")
+
+	cmd.SetLongDescription(`
+Copy a local file to <path> on member systems
+
+The file is stored in chunks under cascade/files/<checksum>/ for the
+duration of the push, and written atomically by agents started with
+--allow-push once its checksum is verified. As with cm roll, nodes
+outside their maintenance windows are refused unless --wait-window is
+set.
+  `)
+
+	return cmd
+}
+
+func pushRun(c cli.Command) {
+	role := c.Flag("role").String()
+	if len(role) == 0 && c.Flag("force").Get() != true {
+		fatalln("Must specify -f option to run with no `role` filter specified")
+	}
+
+	mode, err := strconv.ParseUint(c.Flag("mode").String(), 8, 32)
+	if err != nil {
+		fatalln("err: invalid mode: ", c.Flag("mode").String())
+	}
+
+	data, err := ioutil.ReadFile(c.Param("file").String())
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	roller, err := roll.NewRoll(role, c.Flag("override-freeze").Get() == true)
+	if err != nil {
+		fatalln("Err: ", err)
+	}
+
+	roller.Action = "push"
+	roller.Concurrency = c.Flag("concurrency").Get().(int)
+	roller.WaitForWindow = c.Flag("wait-window").Get() == true
+
+	go func() {
+		for range roller.Msg {
+		}
+	}()
+
+	req := &agent.PushRequest{
+		Path:  c.Param("path").String(),
+		Mode:  uint32(mode),
+		Owner: c.Flag("owner").String(),
+	}
+
+	consul := consulClient()
+	kv := consul.KV()
+
+	if err := agent.StoreFile(kv, data, req); err != nil {
+		roller.Destroy()
+		fatalln("err: ", err)
+	}
+
+	client := message.NewClient()
+	client.Timeout = c.Flag("timeout").Get().(time.Duration)
+	client.Retries = c.Flag("retries").Get().(int)
+
+	fmt.Printf("Pushing %s (%d bytes, sha256 %s) to (%v) nodes..\n", req.Path, len(data), req.Checksum, len(roller.Nodes))
+
+	var mu sync.Mutex
+	failed := false
+
+	ctx, cancel := interruptible(roller)
+	err = roller.Each(ctx, func(ctx context.Context, node string, report *roll.NodeReport) error {
+		_, err := client.Send(ctx, node, agent.PushMessage, req)
+		if ctx.Err() != nil {
+			return roll.ErrRollAborted
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil {
+			failed = true
+			fmt.Printf("  - %s: %s\n", node, err)
+		} else {
+			fmt.Printf("  - %s: ok\n", node)
+		}
+
+		return nil
+	})
+	cancel()
+
+	kv.DeleteTree(req.Key, nil)
+	roller.Destroy()
+
+	if err != nil {
+		fatalln("Err: ", err)
+	}
+
+	if failed {
+		exit(1)
+	}
+}
@@ -19,18 +19,19 @@ package command
 
 import (
 	"fmt"
-	"log"
+	"sort"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/jwaldrip/odin/cli"
-)
 
-var Role = cli.NewSubCommand("role", "Role operations", roleRun)
+	"github.com/boundary/cascade/roll"
+)
 
-func init() {
-	Role.DefineParams("action")
+func newRole() *cli.SubCommand {
+	cmd := cli.NewSubCommand("role", "Role operations", roleRun)
+	cmd.DefineParams("action")
 
-	Role.SetLongDescription(`
+	cmd.SetLongDescription(`
 Interact with cascade roles
 
 Actions:
@@ -40,7 +41,29 @@ Actions:
   set <roles> - set local roles (replaces current)
   append <roles> - append roles to local set
   rm <roles> - remove roles from local set
+  window <role> [<windows>] - show or set (replaces) maintenance windows
+  window <role> none - remove maintenance windows
+  pin <role> [<revision>] - show or set the CM revision nodes of a role apply
+  pin <role> none - unpin the revision
+
+With cascade --output json|yaml, list, listAll and find print a list of
+  {node, address, roles: []}
+
+Maintenance windows limit when a role's nodes may be rolled, e.g.
+
+  cascade role window payments "mon-fri 22:00-04:00 Europe/London"
+
+Days are a comma separated list of days or ranges (sun..sat), or * for
+every day. A window ending before it starts closes the following day.
+Times are UTC unless a timezone is given. Nodes with several restricted
+roles are only eligible while all of their windows are open.
+
+Pinned revisions are passed to nodes with each run as CASCADE_REVISION,
+and cascade cm roll --only-outdated targets nodes that last applied a
+different revision.
   `)
+
+	return cmd
 }
 
 func roleRun(c cli.Command) {
@@ -57,58 +80,89 @@ func roleRun(c cli.Command) {
 		roleAppend(c)
 	case "rm":
 		roleRm(c)
+	case "window":
+		roleWindow(c)
+	case "pin":
+		rolePin(c)
 	default:
 		cli.ShowUsage(c)
 	}
 }
 
-func roleListAll(_ cli.Command) {
+func roleListAll(c cli.Command) {
 
 	roles, err := allNodeRoles()
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
-	for k, v := range roles {
-		printRole(k, v)
+	entries, err := nodeRoleEntries()
+	if err != nil {
+		fatalln("err: ", err)
 	}
+
+	roleListing(entries, func() {
+		for k, v := range roles {
+			printRole(k, v)
+		}
+	}).print(c)
 }
 
-func roleList(_ cli.Command) {
+func roleList(c cli.Command) {
 
 	nodeRoles, err := allNodeRoles()
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
-	myKey, err := selfKey()
+	name, addr, err := selfNode()
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
-	printRole(myKey, nodeRoles[myKey])
+
+	myKey := makeKey(name, addr)
+	entries := []*RoleOutput{{Node: name, Address: addr, Roles: stringList(nodeRoles[myKey])}}
+
+	roleListing(entries, func() {
+		printRole(myKey, nodeRoles[myKey])
+	}).print(c)
 }
 
 func roleFind(c cli.Command) {
 	cmdRoles := c.Args().Strings()
 	if len(cmdRoles) == 0 {
-		log.Fatalln("Must specify a role to find")
+		fatalln("Must specify a role to find")
 	}
 	if len(cmdRoles) != 1 {
 		// maybe we could support multiple but i don't think it's necessary
-		log.Fatalln("One role only is supported for `find` command")
+		fatalln("One role only is supported for `find` command")
 	}
 	role := cmdRoles[0]
 	allRoles, err := allNodeRoles()
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
+	}
+
+	all, err := nodeRoleEntries()
+	if err != nil {
+		fatalln("err: ", err)
 	}
 
-	fmt.Printf("All nodes containing role %s:\n\n", role)
-	for node, r := range allRoles {
-		if StrContains(r, role) {
-			printRole(node, r)
+	entries := make([]*RoleOutput, 0)
+	for _, e := range all {
+		if StrContains(e.Roles, role) {
+			entries = append(entries, e)
 		}
 	}
+
+	roleListing(entries, func() {
+		fmt.Printf("All nodes containing role %s:\n\n", role)
+		for node, r := range allRoles {
+			if StrContains(r, role) {
+				printRole(node, r)
+			}
+		}
+	}).print(c)
 }
 
 func roleSet(c cli.Command) {
@@ -116,7 +170,7 @@ func roleSet(c cli.Command) {
 }
 
 func roleActualSet(roles []string, c cli.Command) {
-	client, _ := api.NewClient(api.DefaultConfig())
+	client := consulClient()
 	agent := client.Agent()
 
 	reg := &api.AgentServiceRegistration{
@@ -125,7 +179,7 @@ func roleActualSet(roles []string, c cli.Command) {
 	}
 
 	if err := agent.ServiceRegister(reg); err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
 	roleList(c)
@@ -134,12 +188,12 @@ func roleActualSet(roles []string, c cli.Command) {
 func roleAppend(c cli.Command) {
 	nodeRoles, err := allNodeRoles()
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
 	myKey, err := selfKey()
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
 	var finalSet []string
@@ -159,17 +213,17 @@ func roleAppend(c cli.Command) {
 func roleRm(c cli.Command) {
 	rmRoles := c.Args().Strings()
 	if (len(rmRoles) == 0) {
-		log.Fatalln("Must specify some role[s] to remove")
+		fatalln("Must specify some role[s] to remove")
 	}
 
 	nodeRoles, err := allNodeRoles()
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
 	myKey, err := selfKey()
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
 	var finalSet []string
@@ -183,10 +237,80 @@ func roleRm(c cli.Command) {
 	roleActualSet(finalSet, c)
 }
 
+func roleWindow(c cli.Command) {
+	args := c.Args().Strings()
+	if len(args) == 0 {
+		fatalln("Must specify a role")
+	}
+
+	client := consulClient()
+	kv := client.KV()
+	role := args[0]
+
+	if len(args) > 1 {
+		specs := args[1:]
+		if len(specs) == 1 && specs[0] == "none" {
+			specs = nil
+		}
+
+		if err := roll.PutWindows(kv, role, specs); err != nil {
+			fatalln("err: ", err)
+		}
+	}
+
+	windows, err := roll.GetWindows(kv)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if len(windows[role]) == 0 {
+		fmt.Printf("role `%s` may be rolled at any time\n", role)
+		return
+	}
+
+	fmt.Printf("role `%s` may be rolled during:\n", role)
+	for _, w := range windows[role] {
+		fmt.Println("  -", w.Spec)
+	}
+}
+
+func rolePin(c cli.Command) {
+	args := c.Args().Strings()
+	if len(args) == 0 || len(args) > 2 {
+		fatalln("Must specify a role and optionally a revision")
+	}
+
+	client := consulClient()
+	kv := client.KV()
+	role := args[0]
+
+	if len(args) == 2 {
+		revision := args[1]
+		if revision == "none" {
+			revision = ""
+		}
+
+		if err := roll.PutRevision(kv, role, revision); err != nil {
+			fatalln("err: ", err)
+		}
+	}
+
+	revisions, err := roll.GetRevisions(kv)
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	if revisions[role] == "" {
+		fmt.Printf("role `%s` is not pinned\n", role)
+		return
+	}
+
+	fmt.Printf("role `%s` is pinned to %s\n", role, revisions[role])
+}
 
 func allNodeRoles() (map[string][]string, error) {
 	roleMap := make(map[string][]string)
-	client, _ := api.NewClient(api.DefaultConfig())
+	client := consulClient()
 	catalog := client.Catalog()
 	cascadeServices, _, err := catalog.Service("cascade", "", nil)
 	if err != nil {
@@ -199,17 +323,50 @@ func allNodeRoles() (map[string][]string, error) {
 	return roleMap, nil
 }
 
+// nodeRoleEntries returns the roles of all nodes sorted by node
+func nodeRoleEntries() ([]*RoleOutput, error) {
+	client := consulClient()
+
+	services, _, err := client.Catalog().Service("cascade", "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*RoleOutput, 0)
+	for _, service := range services {
+		entries = append(entries, &RoleOutput{Node: service.Node, Address: service.Address, Roles: stringList(service.ServiceTags)})
+	}
+
+	sort.Sort(byNode(entries))
+	return entries, nil
+}
+
+type byNode []*RoleOutput
+
+func (r byNode) Len() int           { return len(r) }
+func (r byNode) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byNode) Less(i, j int) bool { return r[i].Node < r[j].Node }
+
 func selfKey() (string, error) {
-	client, _ := api.NewClient(api.DefaultConfig())
+	name, addr, err := selfNode()
+	if err != nil {
+		return "", err
+	}
+
+	return makeKey(name, addr), nil
+}
+
+func selfNode() (string, string, error) {
+	client := consulClient()
 	agent := client.Agent()
 
 	self, err := agent.Self()
 
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return makeKey(self["Config"]["NodeName"].(string), self["Config"]["AdvertiseAddr"].(string)), nil
+	return self["Config"]["NodeName"].(string), self["Config"]["AdvertiseAddr"].(string), nil
 }
 
 func makeKey(hostName string, hostAddr string) (string) {
@@ -19,28 +19,33 @@ package command
 
 import (
 	"fmt"
-	"log"
 	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/hashicorp/consul/api"
 	"github.com/jwaldrip/odin/cli"
 )
 
-var Service = cli.NewSubCommand("service", "Service operations", serviceRun)
-
-func init() {
-	Service.DefineParams("action")
-	Service.DefineStringFlag("type", "", "filter by type")
-	Service.AliasFlag('t', "type")
-	Service.SetLongDescription(`
+func newService() *cli.SubCommand {
+	cmd := cli.NewSubCommand("service", "Service operations", serviceRun)
+	cmd.DefineParams("action")
+	cmd.DefineStringFlag("type", "", "filter by type")
+	cmd.AliasFlag('t', "type")
+	cmd.SetLongDescription(`
 Interact with cascade services
 
 Actions:
   list - list registered services
   local - list services on current node
   find <servicename> - list nodes with service
+
+With cascade --output json|yaml, list prints a list of
+  {service, tags: []}
+and local and find print a list of
+  {service, node, address, port, tags: []}
   `)
+
+	return cmd
 }
 
 func serviceRun(c cli.Command) {
@@ -57,17 +62,17 @@ func serviceRun(c cli.Command) {
 }
 
 func serviceList(c cli.Command) {
-	client, _ := api.NewClient(api.DefaultConfig())
+	client := consulClient()
 	catalog := client.Catalog()
 
 	services, meta, err := catalog.Services(nil)
 
 	if err != nil {
-		log.Fatalln("Err:", err)
+		fatalln("Err:", err)
 	}
 
 	if meta.LastIndex == 0 {
-		log.Fatalln("Bad: ", meta)
+		fatalln("Bad: ", meta)
 	}
 
 	sorted := make([]string, 0)
@@ -78,23 +83,35 @@ func serviceList(c cli.Command) {
 
 	sort.Strings(sorted)
 
+	value := make([]*ServiceOutput, 0)
+	rows := make([][]string, 0)
 	for _, service := range sorted {
-		fmt.Println("  -", service)
+		value = append(value, &ServiceOutput{Service: service, Tags: stringList(services[service])})
+		rows = append(rows, []string{service, strings.Join(services[service], ",")})
+	}
+
+	l := &listing{value: value, header: []string{"SERVICE", "TAGS"}, rows: rows}
+	l.text = func() {
+		for _, service := range sorted {
+			fmt.Println("  -", service)
+		}
 	}
+
+	l.print(c)
 }
 
 func serviceLocal(c cli.Command) {
-	client, _ := api.NewClient(api.DefaultConfig())
+	client := consulClient()
 	agent := client.Agent()
 
 	services, err := agent.Services()
 
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
 	// sigh
@@ -110,36 +127,74 @@ func serviceLocal(c cli.Command) {
 
 	sort.Strings(sorted)
 
+	name, addr, err := selfNode()
+	if err != nil {
+		fatalln("err: ", err)
+	}
+
+	instances := make([]*ServiceInstanceOutput, 0)
 	for _, service := range sorted {
-		fmt.Println(service + ":")
 		for _, st := range services {
 			if st.Service == service {
-				fmt.Println("  - port:", st.Port)
-				fmt.Println("    tags:", strings.Join(st.Tags, ", "))
+				instances = append(instances, &ServiceInstanceOutput{service, name, addr, st.Port, stringList(st.Tags)})
 			}
 		}
 	}
+
+	l := serviceListing(instances)
+	l.text = func() {
+		for _, service := range sorted {
+			fmt.Println(service + ":")
+			for _, st := range services {
+				if st.Service == service {
+					fmt.Println("  - port:", st.Port)
+					fmt.Println("    tags:", strings.Join(st.Tags, ", "))
+				}
+			}
+		}
+	}
+
+	l.print(c)
 }
 
 func serviceFind(c cli.Command) {
-	client, _ := api.NewClient(api.DefaultConfig())
+	client := consulClient()
 	catalog := client.Catalog()
 
 	if len(c.Args().GetAll()) == 0 {
-		log.Fatalln("err: missing <servicename> argument")
+		fatalln("err: missing <servicename> argument")
 	}
 
 	nodes, _, err := catalog.Service(c.Arg(0).String(), c.Flag("type").String(), nil)
 
 	if err != nil {
-		log.Fatalln("err: ", err)
+		fatalln("err: ", err)
 	}
 
-	fmt.Println(c.Arg(0).String() + ":")
+	instances := make([]*ServiceInstanceOutput, 0)
 	for _, node := range nodes {
-		fmt.Println("  - host:", node.Node)
-		fmt.Println("    address:", node.Address)
-		fmt.Println("    port:", node.ServicePort)
-		fmt.Println("    tags:", strings.Join(node.ServiceTags, ", "))
+		instances = append(instances, &ServiceInstanceOutput{node.ServiceName, node.Node, node.Address, node.ServicePort, stringList(node.ServiceTags)})
+	}
+
+	l := serviceListing(instances)
+	l.text = func() {
+		fmt.Println(c.Arg(0).String() + ":")
+		for _, node := range nodes {
+			fmt.Println("  - host:", node.Node)
+			fmt.Println("    address:", node.Address)
+			fmt.Println("    port:", node.ServicePort)
+			fmt.Println("    tags:", strings.Join(node.ServiceTags, ", "))
+		}
 	}
+
+	l.print(c)
+}
+
+func serviceListing(instances []*ServiceInstanceOutput) *listing {
+	rows := make([][]string, 0)
+	for _, i := range instances {
+		rows = append(rows, []string{i.Service, i.Node, i.Address, strconv.Itoa(i.Port), strings.Join(i.Tags, ",")})
+	}
+
+	return &listing{value: instances, header: []string{"SERVICE", "NODE", "ADDRESS", "PORT", "TAGS"}, rows: rows}
 }
@@ -0,0 +1,471 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jwaldrip/odin/cli"
+)
+
+// Lines kept in ~/.cascade_history
+const shellHistorySize = 500
+
+// shellUsage is also printed by the help shell command
+const shellUsage = `
+Run cascade commands interactively
+
+  cascade> set role web
+  cascade(web)> node list
+  cascade(web)> cm roll --plan
+
+Tab completes commands, actions, node names, roles, services and roll
+ids, which are read from Consul the first time they're completed (see
+refresh).
+Commands run in the shell itself over one Consul connection. Ctrl-C
+stops a roll, exec, push or cm status --follow as it does outside the
+shell, it never ends the shell.
+
+Shell commands:
+  set output <text|table|json|yaml> - output format of listing commands
+  set role <role> - --role of cm, exec, node and push unless given, shown in the prompt
+  set role none - clear the role
+  set - show the output format and role
+  refresh - read completions from Consul again
+  history - show previous commands
+  help - show this help
+  exit - leave the shell (or Ctrl-D)
+  `
+
+func newShell() *cli.SubCommand {
+	cmd := cli.NewSubCommand("shell", "Interactive cascade shell", shellRun)
+	cmd.SetLongDescription(shellUsage)
+
+	return cmd
+}
+
+// shell is the state kept between commands of a session
+type shell struct {
+	output  string
+	role    string
+	names   catalogNames
+	history []string
+	file    string
+	tty     string
+}
+
+func shellRun(c cli.Command) {
+	sh := &shell{
+		output: outputFormat(c),
+		names:  make(catalogNames),
+		file:   filepath.Join(os.Getenv("HOME"), ".cascade_history"),
+	}
+
+	sh.loadHistory()
+
+	// Without a terminal lines are read as is, with no completion
+	if state, err := stty("-g"); err == nil {
+		sh.tty = strings.TrimSpace(state)
+	}
+
+	// Interrupts are for the running command
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		for range sigs {
+		}
+	}()
+
+	inShell = true
+
+	for {
+		line, err := sh.readLine(sh.prompt())
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			fatalln("err: ", err)
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		sh.addHistory(strings.TrimSpace(line))
+
+		if !sh.builtin(args) {
+			sh.run(args)
+		}
+	}
+}
+
+func (sh *shell) prompt() string {
+	if sh.role != "" {
+		return fmt.Sprintf("cascade(%s)> ", sh.role)
+	}
+
+	return "cascade> "
+}
+
+// builtin runs shell commands, returning false for cascade subcommands
+func (sh *shell) builtin(args []string) bool {
+	switch args[0] {
+	case "exit", "quit":
+		os.Exit(0)
+	case "help":
+		fmt.Println(strings.TrimSpace(shellUsage))
+	case "history":
+		for i, line := range sh.history {
+			fmt.Printf("%5d  %s\n", i+1, line)
+		}
+	case "refresh":
+		sh.names = make(catalogNames)
+	case "set":
+		sh.set(args[1:])
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (sh *shell) set(args []string) {
+	if len(args) == 0 {
+		role := sh.role
+		if role == "" {
+			role = "none"
+		}
+
+		fmt.Printf("output: %s\nrole: %s\n", sh.output, role)
+		return
+	}
+
+	if len(args) != 2 {
+		fmt.Println("err: usage: set <output|role> <value>")
+		return
+	}
+
+	switch args[0] {
+	case "output":
+		switch args[1] {
+		case OutputText, OutputTable, OutputJSON, OutputYAML:
+			sh.output = args[1]
+		default:
+			fmt.Println("err: output must be one of text, table, json or yaml")
+		}
+	case "role":
+		if args[1] == "none" {
+			sh.role = ""
+		} else {
+			sh.role = args[1]
+		}
+	default:
+		fmt.Println("err: usage: set <output|role> <value>")
+	}
+}
+
+// run runs a cascade subcommand in process. Commands exit through exit,
+// which panics in the shell, and odin panics on usage errors once it has
+// printed them.
+func (sh *shell) run(args []string) {
+	if args[0] == "shell" {
+		fmt.Println("err: already in a shell")
+		return
+	}
+
+	defer func() {
+		switch r := recover().(type) {
+		case nil, exited:
+		case runtime.Error:
+			panic(r)
+		case error:
+			// usage errors, printed by odin
+		default:
+			panic(r)
+		}
+	}()
+
+	cascade := NewCLI(cli.PanicOnError)
+	cascade.Start(append([]string{"cascade", "--output", sh.output}, sh.withRole(args)...)...)
+}
+
+// withRole adds the session role to commands filtering by role
+func (sh *shell) withRole(args []string) []string {
+	if sh.role == "" {
+		return args
+	}
+
+	filters := false
+	for _, flag := range commandFlags(args[0]) {
+		filters = filters || flag == "--role"
+	}
+
+	if !filters {
+		return args
+	}
+
+	for _, arg := range args[1:] {
+		if arg == "--" {
+			break
+		}
+
+		if arg == "-r" || arg == "--role" || strings.HasPrefix(arg, "--role=") {
+			return args
+		}
+	}
+
+	return append([]string{args[0], "--role", sh.role}, args[1:]...)
+}
+
+func (sh *shell) loadHistory() {
+	f, err := os.Open(sh.file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sh.history = append(sh.history, scanner.Text())
+	}
+
+	if len(sh.history) > shellHistorySize {
+		sh.history = sh.history[len(sh.history)-shellHistorySize:]
+	}
+}
+
+func (sh *shell) addHistory(line string) {
+	if n := len(sh.history); n > 0 && sh.history[n-1] == line {
+		return
+	}
+
+	sh.history = append(sh.history, line)
+
+	f, err := os.OpenFile(sh.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, line)
+}
+
+// readLine reads a line with history and completion when on a terminal
+func (sh *shell) readLine(prompt string) (string, error) {
+	fmt.Print(prompt)
+
+	if sh.tty == "" {
+		var line []rune
+		for {
+			r, err := stdin.readRune()
+			if err == io.EOF && len(line) > 0 {
+				return string(line), nil
+			} else if err != nil {
+				return "", err
+			}
+
+			if r == '\n' {
+				return strings.TrimRight(string(line), "\r"), nil
+			}
+
+			line = append(line, r)
+		}
+	}
+
+	if _, err := stty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return "", err
+	}
+	defer stty(sh.tty)
+
+	var line []rune
+	pos := len(sh.history)
+
+	redraw := func() {
+		fmt.Print("\r\033[K" + prompt + string(line))
+	}
+
+	for {
+		r, err := stdin.readRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Print("\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Print("^C\r\n")
+			line, pos = nil, len(sh.history)
+			redraw()
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Print("\r\n")
+				return "", io.EOF
+			}
+		case 8, 127:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				redraw()
+			}
+		case '\t':
+			completed, options := sh.complete(string(line))
+			line = []rune(completed)
+			if len(options) > 1 {
+				fmt.Print("\r\n" + strings.Join(options, "  ") + "\r\n")
+			}
+			redraw()
+		case 27: // arrow keys are ESC [ A..D
+			if next, _ := stdin.readRune(); next != '[' {
+				continue
+			}
+
+			switch key, _ := stdin.readRune(); key {
+			case 'A':
+				if pos > 0 {
+					pos--
+					line = []rune(sh.history[pos])
+				}
+			case 'B':
+				if pos < len(sh.history) {
+					pos++
+					line = nil
+					if pos < len(sh.history) {
+						line = []rune(sh.history[pos])
+					}
+				}
+			}
+			redraw()
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line, r)
+				fmt.Print(string(r))
+			}
+		}
+	}
+}
+
+// complete extends the last word of line as far as the candidates
+// agree, returning them when there is more than one
+func (sh *shell) complete(line string) (string, []string) {
+	words := strings.Fields(line)
+	if line == "" || strings.HasSuffix(line, " ") {
+		words = append(words, "")
+	}
+
+	// names are read once per kind, cm local works without Consul so
+	// the shell does too
+	if kind := completionKind(words); kind != "" && sh.names[kind] == nil {
+		names, err := loadNames(kind)
+		if err != nil {
+			return line, nil
+		}
+
+		sh.names[kind] = names
+	}
+
+	candidates := complete(words, sh.names)
+	if len(candidates) == 0 {
+		return line, nil
+	}
+
+	last := words[len(words)-1]
+	common := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, common) {
+			_, size := utf8.DecodeLastRuneInString(common)
+			common = common[:len(common)-size]
+		}
+	}
+
+	line = line[:len(line)-len(last)] + common
+	if len(candidates) == 1 {
+		line += " "
+	}
+
+	return line, candidates
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// splitArgs splits a line into words like a shell, honouring quotes
+func splitArgs(line string) ([]string, error) {
+	args := make([]string, 0)
+
+	var word []rune
+	var quote rune
+	inWord, escaped := false, false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			word = append(word, r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word = append(word, r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, string(word))
+				word, inWord = nil, false
+			}
+		default:
+			word = append(word, r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.New("err: unterminated quote or escape")
+	}
+
+	if inWord {
+		args = append(args, string(word))
+	}
+
+	return args, nil
+}
@@ -22,16 +22,7 @@ import (
 	"github.com/jwaldrip/odin/cli"
 )
 
-var cascade = cli.New("0.0.1", "cascade", cli.ShowUsage)
-
-func init() {
-	cascade.AddSubCommands(
-		command.Cm,
-		command.Node,
-		command.Role,
-		command.Service,
-	)
-}
+var cascade = command.NewCLI(cli.ExitOnError)
 
 func main() {
 	cascade.Start()
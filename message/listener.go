@@ -0,0 +1,172 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/watch"
+)
+
+// Responses are deleted by their sender once read, or after this TTL
+// (up to twice it) if the sender has given up
+const responseTTL = "60s"
+
+// Handler answers a request, the returned value is sent as the reply body
+type Handler func(req *Message) (interface{}, error)
+
+// Listener answers requests sent to this node
+type Listener struct {
+	Node string
+
+	handlers map[string]Handler
+
+	kv      *api.KV
+	event   *api.Event
+	session *api.Session
+	watch   *watch.WatchPlan
+}
+
+func NewListener(node string) *Listener {
+	client, _ := api.NewClient(api.DefaultConfig())
+
+	return &Listener{
+		Node:     node,
+		handlers: make(map[string]Handler),
+		kv:       client.KV(),
+		event:    client.Event(),
+		session:  client.Session(),
+	}
+}
+
+// Handle registers the handler for a request type
+func (l *Listener) Handle(typ string, h Handler) {
+	l.handlers[typ] = h
+}
+
+// Run answers requests until stopped
+func (l *Listener) Run(address string) error {
+	watchParams := make(map[string]interface{})
+	watchParams["type"] = "event"
+	watchParams["name"] = EventName
+
+	watch, err := watch.Parse(watchParams)
+	if err != nil {
+		return err
+	}
+
+	l.watch = watch
+
+	// Events are only a notification, the mailbox tells us whether a
+	// request is still outstanding so old and retried events are harmless
+	l.watch.Handler = func(idx uint64, data interface{}) {
+		for _, event := range data.([]*api.UserEvent) {
+			go l.handle(string(event.Payload))
+		}
+	}
+
+	if err := l.watch.Run(address); err != nil {
+		return errors.New(fmt.Sprintf("err: querying Consul agent: %s", err))
+	}
+
+	return nil
+}
+
+func (l *Listener) Stop() {
+	if l.watch != nil {
+		l.watch.Stop()
+	}
+}
+
+func (l *Listener) handle(id string) {
+	box := mailbox(l.Node, id)
+
+	request, _, err := l.kv.Get(box+"request", nil)
+	if err != nil {
+		log.Println("err: ", err)
+		return
+	}
+
+	// already handled
+	if request == nil {
+		return
+	}
+
+	// Deleting the request claims it, so a retried event is only
+	// handled once
+	if ok, _, err := l.kv.DeleteCAS(request, nil); err != nil {
+		log.Println("err: ", err)
+		return
+	} else if !ok {
+		return
+	}
+
+	req := &Message{}
+	if err := json.Unmarshal(request.Value, req); err != nil {
+		log.Println("err: ", err)
+		return
+	}
+
+	reply := &Message{ID: id, From: l.Node, Type: req.Type}
+
+	if h, ok := l.handlers[req.Type]; !ok {
+		reply.Error = fmt.Sprintf("err: unsupported message type: %s", req.Type)
+	} else if body, err := h(req); err != nil {
+		reply.Error = err.Error()
+	} else if body != nil {
+		if reply.Body, err = json.Marshal(body); err != nil {
+			reply.Error = err.Error()
+		}
+	}
+
+	if err := l.respond(box, reply); err != nil {
+		log.Println("err: ", err)
+	}
+}
+
+// respond writes a reply held by a session that is never renewed, so
+// replies to senders that timed out expire with it
+func (l *Listener) respond(box string, reply *Message) error {
+	value, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+
+	se := &api.SessionEntry{
+		Name:     EventName,
+		TTL:      responseTTL,
+		Behavior: api.SessionBehaviorDelete,
+	}
+
+	sessionID, _, err := l.session.CreateNoChecks(se, nil)
+	if err != nil {
+		return err
+	}
+
+	if ok, _, err := l.kv.Acquire(&api.KVPair{Key: box + "response", Value: value, Session: sessionID}, nil); err != nil {
+		return err
+	} else if !ok {
+		return errors.New(fmt.Sprintf("err: failed to write response to %s", box))
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package message provides point to point request/response messaging
+// with a single node. Requests and responses are stored in a per-node
+// KV mailbox, a cascade.msg user event targeted at the node only carries
+// the request ID, so bodies aren't bound by the user event size limit.
+package message
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	EventName     = "cascade.msg"
+	MailboxPrefix = "cascade/mailbox/"
+
+	DefaultTimeout = 30 * time.Second
+)
+
+var ErrTimeout = errors.New("err: timed out waiting for reply")
+
+// Message is a request to, or reply from, a node
+type Message struct {
+	ID    string          `json:"id"`
+	From  string          `json:"from"`
+	Type  string          `json:"type"`
+	Body  json.RawMessage `json:"body,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// Decode unmarshals the body of a message
+func (m *Message) Decode(v interface{}) error {
+	if len(m.Body) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(m.Body, v)
+}
+
+func mailbox(node string, id string) string {
+	return fmt.Sprintf("%s%s/%s/", MailboxPrefix, node, id)
+}
+
+// Client sends requests to nodes and waits for their replies
+type Client struct {
+	// Timeout is per attempt, a request is sent Retries+1 times before
+	// giving up
+	Timeout time.Duration
+	Retries int
+
+	kv    *api.KV
+	event *api.Event
+	from  string
+}
+
+func NewClient() *Client {
+	client, _ := api.NewClient(api.DefaultConfig())
+	from, _ := os.Hostname()
+
+	return &Client{
+		Timeout: DefaultTimeout,
+		kv:      client.KV(),
+		event:   client.Event(),
+		from:    from,
+	}
+}
+
+// Send delivers a request of the given type to exactly one node and
+// returns its reply. A reply carrying an error is returned as an error,
+// and ctx.Err() once ctx is done.
+func (c *Client) Send(ctx context.Context, node string, typ string, body interface{}) (*Message, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &Message{ID: id, From: c.from, Type: typ}
+	if body != nil {
+		if req.Body, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	value, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	box := mailbox(node, id)
+	defer c.kv.DeleteTree(box, nil)
+
+	if _, err := c.kv.Put(&api.KVPair{Key: box + "request", Value: value}, nil); err != nil {
+		return nil, err
+	}
+
+	params := &api.UserEvent{
+		Name:       EventName,
+		Payload:    []byte(id),
+		NodeFilter: fmt.Sprintf("^%s$", regexp.QuoteMeta(node)),
+	}
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if _, _, err := c.event.Fire(params, nil); err != nil {
+			return nil, err
+		}
+
+		reply, err := c.wait(ctx, box+"response", c.Timeout)
+		if err == ErrTimeout {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if reply.Error != "" {
+			return reply, errors.New(reply.Error)
+		}
+
+		return reply, nil
+	}
+
+	return nil, ErrTimeout
+}
+
+type getResult struct {
+	pair *api.KVPair
+	meta *api.QueryMeta
+	err  error
+}
+
+// wait blocks until a reply is written to key, the timeout passes or ctx
+// is done
+func (c *Client) wait(ctx context.Context, key string, timeout time.Duration) (*Message, error) {
+	deadline := time.Now().Add(timeout)
+	var index uint64
+
+	for {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return nil, ErrTimeout
+		}
+
+		// The blocking query can't be cancelled, it's left to finish
+		// within remaining once ctx is done
+		ch := make(chan getResult, 1)
+		go func(index uint64) {
+			pair, meta, err := c.kv.Get(key, &api.QueryOptions{WaitIndex: index, WaitTime: remaining})
+			ch <- getResult{pair, meta, err}
+		}(index)
+
+		var res getResult
+		select {
+		case res = <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		pair, meta, err := res.pair, res.meta, res.err
+		if err != nil {
+			return nil, err
+		}
+
+		if pair != nil {
+			reply := &Message{}
+			if err := json.Unmarshal(pair.Value, reply); err != nil {
+				return nil, err
+			}
+
+			return reply, nil
+		}
+
+		index = meta.LastIndex
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
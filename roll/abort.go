@@ -0,0 +1,104 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	AbortKey = RollKey + "/abort"
+
+	// AbortMsg prefixes messages sent when an abort is requested
+	AbortMsg = "abort"
+)
+
+// Abort asks the running roll to stop after the current batch, or
+// immediately with Now set
+type Abort struct {
+	User string    `json:"user"`
+	Now  bool      `json:"now"`
+	Time time.Time `json:"time"`
+}
+
+// RequestAbort signals the roll holding the lock, returning the lock
+// holder
+func RequestAbort(kv *api.KV, a *Abort) (string, error) {
+	pair, _, err := kv.Get(RollKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if pair == nil || pair.Session == "" {
+		return "", errors.New("err: no roll in progress")
+	}
+
+	value, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := kv.Put(&api.KVPair{Key: AbortKey, Value: value}, nil); err != nil {
+		return "", err
+	}
+
+	return string(pair.Value), nil
+}
+
+// watchAbort stops or aborts the roll when an abort is requested, until
+// ctx is done
+func (r *Roll) watchAbort(ctx context.Context, abort context.CancelFunc) {
+	var index uint64
+	stopped := false
+
+	for ctx.Err() == nil {
+		pair, meta, err := r.kv.Get(AbortKey, &api.QueryOptions{WaitIndex: index, WaitTime: 5 * time.Second})
+		if err != nil {
+			sleep(ctx, time.Second, nil)
+			continue
+		}
+
+		index = meta.LastIndex
+
+		a := &Abort{}
+		if pair == nil || json.Unmarshal(pair.Value, a) != nil {
+			continue
+		}
+
+		r.mu.Lock()
+		r.abortedBy = a.User
+		r.mu.Unlock()
+
+		if a.Now {
+			r.Msg <- AbortMsg + " now " + a.User
+			abort()
+			return
+		}
+
+		if !stopped {
+			stopped = true
+			r.Msg <- AbortMsg + " stop " + a.User
+			r.Stop()
+		}
+	}
+}
@@ -0,0 +1,230 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	ApprovalPrefix = "cascade/approval/"
+
+	// PauseMsg prefixes messages sent while a roll waits at a gate,
+	// IgnoredMsg those sent when a two-person approval is refused
+	PauseMsg   = "pause"
+	IgnoredMsg = "ignored"
+
+	// Gates
+	PauseBatch = "batch"
+	PauseRole  = "role"
+)
+
+var ErrRollAborted = errors.New("err: roll aborted")
+
+// Approval is a roll waiting at a gate under cascade/approval/<roll id>,
+// the decision is continue or abort
+type Approval struct {
+	Stage     string    `json:"stage"`
+	Requested time.Time `json:"requested"`
+	Decision  string    `json:"decision,omitempty"`
+	User      string    `json:"user,omitempty"`
+}
+
+// GetApproval returns the gate a roll waits at, nil if it isn't waiting
+func GetApproval(kv *api.KV, id string) (*Approval, uint64, error) {
+	pair, _, err := kv.Get(ApprovalPrefix+id, nil)
+	if err != nil || pair == nil {
+		return nil, 0, err
+	}
+
+	a := &Approval{}
+	if err := json.Unmarshal(pair.Value, a); err != nil {
+		return nil, 0, err
+	}
+
+	return a, pair.ModifyIndex, nil
+}
+
+// Decide answers the gate a roll is waiting at
+func Decide(kv *api.KV, id string, user string, decision string) error {
+	if decision != "continue" && decision != "abort" {
+		return errors.New(fmt.Sprintf("err: decision must be continue or abort: %s", decision))
+	}
+
+	a, index, err := GetApproval(kv, id)
+	if err != nil {
+		return err
+	}
+
+	if a == nil || a.Decision != "" {
+		return errors.New(fmt.Sprintf("err: roll %s is not waiting for approval", id))
+	}
+
+	a.Decision = decision
+	a.User = user
+
+	value, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	// check-and-set so only one decision is taken
+	ok, _, err := kv.CAS(&api.KVPair{Key: ApprovalPrefix + id, Value: value, ModifyIndex: index}, nil)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errors.New(fmt.Sprintf("err: roll %s was decided concurrently", id))
+	}
+
+	return nil
+}
+
+// CheckPauseAfter returns an error for PauseAfter gates the roll's nodes
+// can never reach, rather than rolling through them unattended
+func (r *Roll) CheckPauseAfter() error {
+	stages := make(map[string]bool)
+	for _, node := range r.Nodes {
+		if stage := r.stages[node]; stage != "" {
+			stages[stage] = true
+		}
+	}
+
+	for _, p := range r.PauseAfter {
+		switch {
+		case p == PauseBatch:
+		case p == PauseRole:
+			if len(stages) < 2 {
+				return errors.New("err: --pause-after role needs nodes of at least two run_order roles")
+			}
+		case !stages[p]:
+			return errors.New(fmt.Sprintf("err: --pause-after %s: no nodes of the roll are in that run_order role", p))
+		}
+	}
+
+	return nil
+}
+
+// pauseAt returns the gate, if any, between two consecutive batches
+func (r *Roll) pauseAt(batch int, last string, next string) string {
+	for _, p := range r.PauseAfter {
+		switch p {
+		case PauseBatch:
+			return fmt.Sprintf("batch %d", batch)
+		case PauseRole:
+			if r.stages[last] != r.stages[next] {
+				return r.stages[last]
+			}
+		default:
+			if r.stages[last] == p && r.stages[next] != p {
+				return p
+			}
+		}
+	}
+
+	return ""
+}
+
+// gate holds the roll until an operator decides to continue or abort.
+// With TwoPerson set only another user may continue. Users are the
+// self-reported CurrentUser so this is advisory, it guards against
+// mistakes rather than a determined operator.
+func (r *Roll) gate(ctx context.Context, stage string) error {
+	key := ApprovalPrefix + r.ID
+
+	value, _ := json.Marshal(&Approval{Stage: stage, Requested: time.Now()})
+	if _, err := r.kv.Put(&api.KVPair{Key: key, Value: value}, nil); err != nil {
+		return err
+	}
+	defer r.kv.Delete(key, nil)
+
+	r.history.Approvals = append(r.history.Approvals, &Approval{Stage: stage, Requested: time.Now()})
+	approval := r.history.Approvals[len(r.history.Approvals)-1]
+
+	if err := PutHistory(r.kv, r.history); err != nil {
+		return err
+	}
+
+	r.setPaused(stage)
+	defer r.setPaused("")
+
+	r.Msg <- fmt.Sprintf("%s %s", PauseMsg, stage)
+
+	var index uint64
+	for {
+		// short blocking queries so Stop and ctx are noticed
+		if err := r.interrupted(ctx); err != nil {
+			return err
+		}
+
+		pair, meta, err := r.kv.Get(key, &api.QueryOptions{WaitIndex: index, WaitTime: 5 * time.Second})
+		if err != nil {
+			return err
+		}
+
+		if err := r.renew(); err != nil {
+			return err
+		}
+
+		index = meta.LastIndex
+
+		a := &Approval{}
+		if pair == nil {
+			return errors.New("err: approval request removed")
+		} else if err := json.Unmarshal(pair.Value, a); err != nil {
+			return err
+		}
+
+		if a.Decision == "" {
+			continue
+		}
+
+		if a.Decision == "continue" && r.TwoPerson && a.User == r.history.User {
+			r.Msg <- fmt.Sprintf("%s %s", IgnoredMsg, a.User)
+
+			// check-and-set so a decision by another operator landing
+			// meanwhile isn't lost
+			a.Decision, a.User = "", ""
+			value, _ := json.Marshal(a)
+			if _, _, err := r.kv.CAS(&api.KVPair{Key: key, Value: value, ModifyIndex: pair.ModifyIndex}, nil); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		approval.Decision, approval.User = a.Decision, a.User
+
+		if err := PutHistory(r.kv, r.history); err != nil {
+			return err
+		}
+
+		if a.Decision == "abort" {
+			return ErrRollAborted
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,81 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const CordonPrefix = "cascade/cordon/"
+
+// Cordon excludes a node from rolls
+type Cordon struct {
+	Node   string    `json:"node"`
+	Reason string    `json:"reason"`
+	User   string    `json:"user"`
+	Time   time.Time `json:"time"`
+}
+
+func (c *Cordon) String() string {
+	s := "cordoned by " + c.User + " at " + c.Time.Format(time.RFC3339)
+	if c.Reason != "" {
+		s += ": " + c.Reason
+	}
+
+	return s
+}
+
+func SetCordon(kv *api.KV, node string, reason string) error {
+	value, err := json.Marshal(&Cordon{node, reason, CurrentUser(), time.Now()})
+	if err != nil {
+		return err
+	}
+
+	_, err = kv.Put(&api.KVPair{Key: CordonPrefix + node, Value: value}, nil)
+	return err
+}
+
+func RemoveCordon(kv *api.KV, node string) error {
+	_, err := kv.Delete(CordonPrefix+node, nil)
+	return err
+}
+
+// GetCordons returns cordoned nodes keyed by node name
+func GetCordons(kv *api.KV) (map[string]*Cordon, error) {
+	pairs, _, err := kv.List(CordonPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cordons := make(map[string]*Cordon)
+	for _, pair := range pairs {
+		c := &Cordon{}
+		if err := json.Unmarshal(pair.Value, c); err != nil {
+			return nil, err
+		}
+
+		c.Node = strings.TrimPrefix(pair.Key, CordonPrefix)
+		cordons[c.Node] = c
+	}
+
+	return cordons, nil
+}
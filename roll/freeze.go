@@ -0,0 +1,166 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const FreezeKey = "cascade/freeze"
+
+// Repeats are in calendar days so they keep their wall clock time across
+// daylight saving changes
+var repeatDays = map[string]int{
+	"":       0,
+	"daily":  1,
+	"weekly": 7,
+}
+
+// Freeze stops rolls from starting, either manually or during
+// scheduled windows
+type Freeze struct {
+	Active  bool            `json:"active"`
+	Reason  string          `json:"reason,omitempty"`
+	User    string          `json:"user,omitempty"`
+	Time    time.Time       `json:"time"`
+	Windows []*FreezeWindow `json:"windows,omitempty"`
+}
+
+// FreezeWindow is a scheduled freeze, optionally repeating daily or
+// weekly from its first occurrence. Repeats keep the wall clock time of
+// the start in Location, or its UTC offset if none is set.
+type FreezeWindow struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Repeat   string    `json:"repeat,omitempty"`
+	Location string    `json:"location,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	User     string    `json:"user"`
+}
+
+func NewFreezeWindow(start time.Time, end time.Time, repeat string, location string, reason string) (*FreezeWindow, error) {
+	days, ok := repeatDays[repeat]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("err: repeat must be daily or weekly: %s", repeat))
+	}
+
+	if !end.After(start) {
+		return nil, errors.New("err: freeze window must end after it starts")
+	}
+
+	if days > 0 && end.Sub(start) >= time.Duration(days)*24*time.Hour {
+		return nil, errors.New("err: repeating freeze window is longer than its period")
+	}
+
+	if _, err := time.LoadLocation(location); err != nil {
+		return nil, errors.New(fmt.Sprintf("err: unknown timezone: %s", location))
+	}
+
+	return &FreezeWindow{start, end, repeat, location, reason, CurrentUser()}, nil
+}
+
+// Covers reports whether t falls in an occurrence of the window
+func (w *FreezeWindow) Covers(t time.Time) bool {
+	if t.Before(w.Start) {
+		return false
+	}
+
+	start := w.Start
+	if w.Location != "" {
+		if loc, err := time.LoadLocation(w.Location); err == nil {
+			start = start.In(loc)
+		}
+	}
+
+	// estimate the occurrence from elapsed time then step by calendar
+	// days, which are 23 or 25 hours long across daylight saving changes
+	if days := repeatDays[w.Repeat]; days > 0 {
+		n := int(t.Sub(start) / (time.Duration(days) * 24 * time.Hour))
+		for n > 0 && start.AddDate(0, 0, n*days).After(t) {
+			n--
+		}
+		for !start.AddDate(0, 0, (n+1)*days).After(t) {
+			n++
+		}
+
+		start = start.AddDate(0, 0, n*days)
+	}
+
+	return t.Before(start.Add(w.End.Sub(w.Start)))
+}
+
+func (w *FreezeWindow) String() string {
+	s := fmt.Sprintf("%s - %s", w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339))
+	if w.Repeat != "" && w.Location != "" {
+		s += " (" + w.Repeat + " " + w.Location + ")"
+	} else if w.Repeat != "" {
+		s += " (" + w.Repeat + ")"
+	}
+
+	if w.Reason != "" {
+		s += ": " + w.Reason
+	}
+
+	return s
+}
+
+// Frozen returns why rolls are frozen at t, or "" if they aren't
+func (f *Freeze) Frozen(t time.Time) string {
+	if f.Active {
+		return fmt.Sprintf("frozen by %s at %s: %s", f.User, f.Time.Format(time.RFC3339), f.Reason)
+	}
+
+	for _, w := range f.Windows {
+		if w.Covers(t) {
+			return "frozen during window " + w.String()
+		}
+	}
+
+	return ""
+}
+
+// GetFreeze returns the freeze record, which is empty if never set
+func GetFreeze(kv *api.KV) (*Freeze, error) {
+	f := &Freeze{}
+
+	pair, _, err := kv.Get(FreezeKey, nil)
+	if err != nil || pair == nil {
+		return f, err
+	}
+
+	if err := json.Unmarshal(pair.Value, f); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func PutFreeze(kv *api.KV, f *Freeze) error {
+	value, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	_, err = kv.Put(&api.KVPair{Key: FreezeKey, Value: value}, nil)
+	return err
+}
@@ -0,0 +1,71 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import "testing"
+
+func TestFreezeWindowCovers(t *testing.T) {
+	once := &FreezeWindow{Start: mustTime(t, "2026-10-19T10:00:00Z"), End: mustTime(t, "2026-10-19T12:00:00Z")}
+
+	daily := *once
+	daily.Repeat = "daily"
+
+	weekly := *once
+	weekly.Repeat = "weekly"
+
+	// 22:00-23:00 London time, daylight saving starts on 2026-03-29
+	london := &FreezeWindow{
+		Start:    mustTime(t, "2026-03-27T22:00:00Z"),
+		End:      mustTime(t, "2026-03-27T23:00:00Z"),
+		Repeat:   "daily",
+		Location: "Europe/London",
+	}
+
+	offset := *london
+	offset.Location = ""
+
+	tests := []struct {
+		name   string
+		window *FreezeWindow
+		at     string
+		want   bool
+	}{
+		{"once before", once, "2026-10-19T09:59:00Z", false},
+		{"once start", once, "2026-10-19T10:00:00Z", true},
+		{"once during", once, "2026-10-19T11:59:00Z", true},
+		{"once end", once, "2026-10-19T12:00:00Z", false},
+		{"once next day", once, "2026-10-20T11:00:00Z", false},
+		{"daily before first", &daily, "2026-10-18T11:00:00Z", false},
+		{"daily repeat", &daily, "2026-10-22T11:00:00Z", true},
+		{"daily after repeat", &daily, "2026-10-22T12:30:00Z", false},
+		{"weekly repeat", &weekly, "2026-10-26T11:00:00Z", true},
+		{"weekly off day", &weekly, "2026-10-20T11:00:00Z", false},
+		{"london first", london, "2026-03-27T22:30:00Z", true},
+		{"london dst day", london, "2026-03-29T21:30:00Z", true},
+		{"london after dst", london, "2026-03-30T21:30:00Z", true},
+		{"london after dst late", london, "2026-03-30T22:30:00Z", false},
+		{"offset after dst", &offset, "2026-03-30T21:30:00Z", false},
+		{"offset after dst late", &offset, "2026-03-30T22:30:00Z", true},
+	}
+
+	for _, test := range tests {
+		if got := test.window.Covers(mustTime(t, test.at)); got != test.want {
+			t.Errorf("%s: Covers(%s) = %v, want %v", test.name, test.at, got, test.want)
+		}
+	}
+}
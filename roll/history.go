@@ -0,0 +1,146 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	HistoryPrefix = "cascade/history/"
+
+	// Most roll records kept, older ones are deleted when a roll ends
+	HistoryLimit = 1000
+)
+
+// History records a roll under cascade/history/<roll id>
+type History struct {
+	ID       string        `json:"id"`
+	User     string        `json:"user"`
+	Role     string        `json:"role"`
+	Action   string        `json:"action"`
+	Nodes    []string      `json:"nodes"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished,omitempty"`
+	Result   string        `json:"result"`
+	Report   []*NodeReport `json:"report,omitempty"`
+
+	// Revisions dispatched to nodes and those they had applied before
+	Revisions map[string]string `json:"revisions,omitempty"`
+	Previous  map[string]string `json:"previous,omitempty"`
+
+	// Set when the roll rolls back another
+	RollbackOf string `json:"rollback_of,omitempty"`
+
+	// Set when the roll was started during a freeze
+	FreezeOverride string `json:"freeze_override,omitempty"`
+
+	// Set when the roll was stopped with `cm abort`
+	AbortedBy string `json:"aborted_by,omitempty"`
+
+	// Gates the roll paused at and who decided them
+	Approvals []*Approval `json:"approvals,omitempty"`
+}
+
+func PutHistory(kv *api.KV, h *History) error {
+	value, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	_, err = kv.Put(&api.KVPair{Key: HistoryPrefix + h.ID, Value: value}, nil)
+	return err
+}
+
+// GetHistory returns the record of a roll, nil if there is none
+func GetHistory(kv *api.KV, id string) (*History, error) {
+	pair, _, err := kv.Get(HistoryPrefix+id, nil)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+
+	h := &History{}
+	if err := json.Unmarshal(pair.Value, h); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// ListHistory returns all roll records, oldest first
+func ListHistory(kv *api.KV) ([]*History, error) {
+	pairs, _, err := kv.List(HistoryPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*History, 0)
+	for _, pair := range pairs {
+		h := &History{}
+		if err := json.Unmarshal(pair.Value, h); err != nil {
+			return nil, err
+		}
+
+		result = append(result, h)
+	}
+
+	sort.Sort(byStarted(result))
+	return result, nil
+}
+
+// ListHistoryIDs returns the ids of all roll records without reading them
+func ListHistoryIDs(kv *api.KV) ([]string, error) {
+	keys, _, err := kv.Keys(HistoryPrefix, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0)
+	for _, key := range keys {
+		ids = append(ids, strings.TrimPrefix(key, HistoryPrefix))
+	}
+
+	return ids, nil
+}
+
+// PruneHistory deletes all but the newest limit roll records
+func PruneHistory(kv *api.KV, limit int) error {
+	history, err := ListHistory(kv)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(history)-limit; i++ {
+		if _, err := kv.Delete(HistoryPrefix+history[i].ID, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type byStarted []*History
+
+func (h byStarted) Len() int           { return len(h) }
+func (h byStarted) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h byStarted) Less(i, j int) bool { return h[i].Started.Before(h[j].Started) }
@@ -0,0 +1,249 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	LogsPrefix = "cascade/logs/"
+
+	logChunkSize     = 4096
+	logMaxValue      = 256 * 1024
+	logFlushInterval = 1 * time.Second
+	logPollWait      = 2 * time.Second
+
+	// Logs of rolls started longer ago are deleted when a roll ends
+	LogRetention = 7 * 24 * time.Hour
+)
+
+func logPrefix(id string, node string) string {
+	return fmt.Sprintf("%s%s/%s/", LogsPrefix, id, node)
+}
+
+func attemptPrefix(id string, node string, attempt int) string {
+	return fmt.Sprintf("%s%03d/", logPrefix(id, node), attempt)
+}
+
+// LogWriter streams node CM output to KV in chunks under
+// cascade/logs/<roll id>/<node>/<attempt>/ so operators can tail it
+type LogWriter struct {
+	kv     *api.KV
+	prefix string
+	seq    int
+
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	failed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewLogWriter starts flushing periodically. Each attempt at a node is
+// logged separately so retries keep the output of failed attempts.
+func NewLogWriter(kv *api.KV, id string, node string, attempt int) *LogWriter {
+	w := &LogWriter{
+		kv:     kv,
+		prefix: attemptPrefix(id, node, attempt),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(logFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.mu.Lock()
+				w.flush()
+				w.mu.Unlock()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+
+	return w
+}
+
+// Write never fails, streaming is best effort and an error would stop
+// the CM run writing to it. Output that can't be stored is kept and
+// retried on the next flush.
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+
+	if w.buf.Len() >= logChunkSize {
+		w.flush()
+	}
+
+	return len(p), nil
+}
+
+// Close flushes any buffered output, it must be called before the run
+// result is replied so tails see all of it
+func (w *LogWriter) Close() error {
+	close(w.stop)
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.flush()
+}
+
+// flush stores buffered output in chunks Consul accepts, logging the
+// first of consecutive failures
+func (w *LogWriter) flush() error {
+	for w.buf.Len() > 0 {
+		n := w.buf.Len()
+		if n > logMaxValue {
+			n = logMaxValue
+		}
+
+		key := fmt.Sprintf("%s%08d", w.prefix, w.seq)
+		value := make([]byte, n)
+		copy(value, w.buf.Bytes())
+
+		if _, err := w.kv.Put(&api.KVPair{Key: key, Value: value}, nil); err != nil {
+			if !w.failed {
+				log.Println("err: storing logs, will retry: ", err)
+				w.failed = true
+			}
+
+			return err
+		}
+
+		w.failed = false
+		w.seq++
+		w.buf.Next(n)
+	}
+
+	return nil
+}
+
+// TailLogs writes a node's output for an attempt as it arrives until stop
+// is closed, at which point any remaining output is drained
+func TailLogs(kv *api.KV, id string, node string, attempt int, stop <-chan struct{}, out io.Writer) error {
+	prefix := attemptPrefix(id, node, attempt)
+	printed := make(map[string]bool)
+	var index uint64
+
+	for {
+		stopping := false
+		opts := &api.QueryOptions{WaitIndex: index, WaitTime: logPollWait}
+
+		select {
+		case <-stop:
+			stopping = true
+			opts = nil
+		default:
+		}
+
+		pairs, meta, err := kv.List(prefix, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, pair := range pairs {
+			if !printed[pair.Key] {
+				printed[pair.Key] = true
+				out.Write(pair.Value)
+			}
+		}
+
+		if stopping {
+			return nil
+		}
+
+		index = meta.LastIndex
+	}
+}
+
+// Logs writes the stored output of a node for a roll, headed by attempt
+// when it was retried
+func Logs(kv *api.KV, id string, node string, out io.Writer) (bool, error) {
+	prefix := logPrefix(id, node)
+
+	pairs, _, err := kv.List(prefix, nil)
+	if err != nil {
+		return false, err
+	}
+
+	attempts := make([]string, 0)
+	for _, pair := range pairs {
+		attempt := strings.SplitN(strings.TrimPrefix(pair.Key, prefix), "/", 2)[0]
+		if len(attempts) == 0 || attempts[len(attempts)-1] != attempt {
+			attempts = append(attempts, attempt)
+		}
+	}
+
+	last := ""
+	for _, pair := range pairs {
+		attempt := strings.SplitN(strings.TrimPrefix(pair.Key, prefix), "/", 2)[0]
+		if len(attempts) > 1 && attempt != last {
+			fmt.Fprintf(out, "--- attempt %s ---\n", strings.TrimLeft(attempt, "0"))
+			last = attempt
+		}
+
+		out.Write(pair.Value)
+	}
+
+	return len(pairs) > 0, nil
+}
+
+// PruneLogs deletes the logs of rolls started more than LogRetention
+// before now, and of rolls without history
+func PruneLogs(kv *api.KV, now time.Time) error {
+	keys, _, err := kv.Keys(LogsPrefix, "/", nil)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		h, err := GetHistory(kv, strings.TrimSuffix(strings.TrimPrefix(key, LogsPrefix), "/"))
+		if err != nil {
+			return err
+		}
+
+		if h != nil && now.Sub(h.Started) < LogRetention {
+			continue
+		}
+
+		if _, err := kv.DeleteTree(key, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	ParamsVersion = 1
+	ParamsPrefix  = "cascade/params/"
+
+	// Consul rejects user events over 512 bytes once encoded, leave room
+	// for the envelope
+	maxPayloadSize = 256
+)
+
+// EventParams are key/value parameters passed to the node side run.
+// When they don't fit in the event payload they are stored in KV and
+// Key references them instead of Values.
+type EventParams struct {
+	Version int               `json:"version"`
+	Values  map[string]string `json:"values,omitempty"`
+	Key     string            `json:"key,omitempty"`
+}
+
+// Resolve returns the parameter values, fetching them from KV if the
+// event only carried a reference
+func (p *EventParams) Resolve(kv *api.KV) (map[string]string, error) {
+	if p == nil {
+		return map[string]string{}, nil
+	}
+
+	if p.Version > ParamsVersion {
+		return nil, errors.New(fmt.Sprintf("err: unsupported params version: %d", p.Version))
+	}
+
+	if p.Key == "" {
+		return p.Values, nil
+	}
+
+	pair, _, err := kv.Get(p.Key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if pair == nil {
+		return nil, errors.New(fmt.Sprintf("err: params not found at %s", p.Key))
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal(pair.Value, &values); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// eventPayload encodes a CascadeEvent, moving the params to KV when the
+// payload would exceed the user event size limit
+func (r *Roll) eventPayload(e CascadeEvent) ([]byte, error) {
+	payload, err := json.Marshal(e)
+	if err != nil || len(payload) <= maxPayloadSize || e.Params == nil {
+		return payload, err
+	}
+
+	key := ParamsPrefix + r.sessionID
+
+	if r.paramsPair == nil {
+		value, err := json.Marshal(e.Params.Values)
+		if err != nil {
+			return nil, err
+		}
+
+		// Tie the params to the roll session so they are cleaned up with it
+		pair := &api.KVPair{Key: key, Value: value, Session: r.sessionID}
+		if work, _, err := r.kv.Acquire(pair, nil); err != nil {
+			return nil, err
+		} else if !work {
+			return nil, errors.New(fmt.Sprintf("err: failed to store params at %s", key))
+		}
+
+		r.paramsPair = pair
+	}
+
+	e.Params = &EventParams{Version: ParamsVersion, Key: key}
+
+	return json.Marshal(e)
+}
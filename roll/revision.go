@@ -0,0 +1,126 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"gopkg.in/yaml.v2"
+)
+
+// RevisionsPrefix pins the CM revision of a role under
+// cascade/revisions/<role>
+const RevisionsPrefix = "cascade/revisions/"
+
+// GetRevisions returns pinned revisions keyed by role
+func GetRevisions(kv *api.KV) (map[string]string, error) {
+	pairs, _, err := kv.List(RevisionsPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make(map[string]string)
+	for _, pair := range pairs {
+		revisions[strings.TrimPrefix(pair.Key, RevisionsPrefix)] = string(pair.Value)
+	}
+
+	return revisions, nil
+}
+
+// PutRevision pins the revision of a role, empty unpins it
+func PutRevision(kv *api.KV, role string, revision string) error {
+	if revision == "" {
+		_, err := kv.Delete(RevisionsPrefix+role, nil)
+		return err
+	}
+
+	_, err := kv.Put(&api.KVPair{Key: RevisionsPrefix + role, Value: []byte(revision)}, nil)
+	return err
+}
+
+// Revision returns the revision dispatched to a node, set in Revisions or
+// else pinned for its roles
+func (r *Roll) Revision(node string) string {
+	if rev := r.Revisions[node]; rev != "" {
+		return rev
+	}
+
+	return pinnedRevision(r.revisions, r.stages[node], r.schedule.roles[node])
+}
+
+// NodeRevision returns the revision pinned for a node with roles, as it
+// would be dispatched by a roll
+func NodeRevision(kv *api.KV, roles []string) (string, error) {
+	revisions, err := GetRevisions(kv)
+	if err != nil {
+		return "", err
+	}
+
+	pair, _, err := kv.Get(RunOrderKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// the run_order role the node would be rolled under
+	stage := ""
+	if pair != nil {
+		order := make([]string, 0)
+		if err := yaml.Unmarshal(pair.Value, &order); err != nil {
+			return "", err
+		}
+
+		for _, role := range order {
+			if stage == "" && containsString(roles, role) {
+				stage = role
+			}
+		}
+	}
+
+	return pinnedRevision(revisions, stage, roles), nil
+}
+
+// pinnedRevision returns the revision pinned for the run_order role a
+// node is rolled under or else the first of its roles with a pin
+func pinnedRevision(revisions map[string]string, stage string, roles []string) string {
+	if rev := revisions[stage]; rev != "" {
+		return rev
+	}
+
+	sorted := append([]string{}, roles...)
+	sort.Strings(sorted)
+
+	for _, role := range sorted {
+		if rev := revisions[role]; rev != "" {
+			return rev
+		}
+	}
+
+	return ""
+}
+
+func containsString(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -18,11 +18,14 @@
 package roll
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/consul/api"
@@ -34,17 +37,79 @@ const (
 	RollKey     = "cascade/roll"
 	RunOrderKey = "cascade/run_order"
 	ConsulHost  = "127.0.0.1:8500"
+	EventName   = "cascade.cm"
+
+	DefaultAction = "run"
 )
 
+// Messages sent by nodes in reply to a dispatch, these can't be used as
+// action names
+var replyMsgs = []string{"meta", "start", "success", "fail"}
+
+// CascadeEvent is the payload of cascade.cm events. Requests carry the
+// action to execute in Msg, replies reference the request event in Ref.
 type CascadeEvent struct {
-	Source string `json:"source"`
-	Msg    string `json:"msg"`
-	Ref    string `json:"ref"`
+	Source   string       `json:"source"`
+	Msg      string       `json:"msg"`
+	Ref      string       `json:"ref"`
+	Roll     string       `json:"roll,omitempty"`
+	Attempt  int          `json:"attempt,omitempty"`
+	Params   *EventParams `json:"params,omitempty"`
+	Revision string       `json:"revision,omitempty"`
+	Changes  int          `json:"changes,omitempty"`
 }
 
+var (
+	ErrNodeFailed  = errors.New("err: failure roll stopped")
+	ErrNodeTimeout = errors.New("err: timed out waiting for node")
+	ErrRollStopped = errors.New("err: roll stopped")
+)
+
+// WindowMsg prefixes messages sent while a node waits for its maintenance
+// window
+const WindowMsg = "window"
+
 type Roll struct {
-	Nodes []string
-	Msg   chan string
+	// The session is unique to the roll so doubles as its ID
+	ID     string
+	Nodes  []string
+	Msg    chan string
+	Action string
+	Params map[string]string
+
+	// Retry policy applied to failed or timed out nodes before the
+	// roll is stopped
+	Retries    int
+	RetryDelay time.Duration
+	Timeout    time.Duration
+
+	// Number of nodes Each works on at once
+	Concurrency int
+
+	// Hold nodes outside their maintenance window until it opens rather
+	// than stopping the roll
+	WaitForWindow bool
+
+	// Gates between batches where the roll waits for an operator: batch,
+	// role (each run_order role) or run_order role names
+	PauseAfter []string
+	TwoPerson  bool
+
+	// Revision dispatched per node, overriding role pins, and the
+	// revision each node had applied before the roll, recorded in
+	// history so the roll can be rolled back
+	Revisions map[string]string
+	Previous  map[string]string
+
+	// Roll this one rolls back, recorded in history
+	RollbackOf string
+
+	Report []*NodeReport
+	mu     sync.Mutex
+
+	stop      chan struct{}
+	stopOnce  sync.Once
+	abortedBy string
 
 	client  *api.Client
 	session *api.Session
@@ -53,24 +118,59 @@ type Roll struct {
 
 	sessionID string
 
-	pair  *api.KVPair
-	watch *watch.WatchPlan
-	curID string
+	pair       *api.KVPair
+	paramsPair *api.KVPair
+	history    *History
+	schedule   *Schedule
+	stages     map[string]string
+	status     *Status
+	revisions  map[string]string
+	watch      *watch.WatchPlan
+	curID      string
+	changes    int
+	curAttempt int
+}
+
+type NodeReport struct {
+	Node     string
+	Status   string
+	Attempts int
+	Changes  int
+	Duration time.Duration
 }
 
-func NewRoll(role string) (*Roll, error) {
+// NewRoll takes the cluster roll lock for the nodes of a role. It fails
+// while changes are frozen unless overrideFreeze is set, in which case
+// the override is recorded in the roll history.
+func NewRoll(role string, overrideFreeze bool) (*Roll, error) {
 	client, _ := api.NewClient(api.DefaultConfig())
 	session := client.Session()
 	kv := client.KV()
 	event := client.Event()
 
-	user := os.Getenv("USER")
+	user := CurrentUser()
 
-	if user == "root" && os.Getenv("SUDO_USER") != "" {
-		user = os.Getenv("SUDO_USER")
+	nodes, stages, err := getNodes(role)
+	if err != nil {
+		return nil, err
 	}
 
-	nodes, err := GetNodes(role)
+	freeze, err := GetFreeze(kv)
+	if err != nil {
+		return nil, err
+	}
+
+	frozen := freeze.Frozen(time.Now())
+	if frozen != "" && !overrideFreeze {
+		return nil, errors.New(fmt.Sprintf("err: changes are %s (use --override-freeze to roll anyway)", frozen))
+	}
+
+	schedule, err := LoadSchedule(client)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := GetRevisions(kv)
 	if err != nil {
 		return nil, err
 	}
@@ -103,50 +203,351 @@ func NewRoll(role string) (*Roll, error) {
 		}
 	}
 
+	// Clear an abort left over from a previous roll
+	if _, err := kv.Delete(AbortKey, nil); err != nil {
+		return nil, err
+	}
+
 	// Setup channel
 	msg := make(chan string, 3)
 
-	return &Roll{nodes, msg, client, session, kv, event, sessionID, pair, nil, ""}, nil
+	return &Roll{
+		ID:        sessionID,
+		Nodes:     nodes,
+		Msg:       msg,
+		Action:    DefaultAction,
+		client:    client,
+		session:   session,
+		kv:        kv,
+		event:     event,
+		sessionID: sessionID,
+		pair:      pair,
+		history:   &History{ID: sessionID, User: user, Role: role, FreezeOverride: frozen},
+		schedule:  schedule,
+		stages:    stages,
+		revisions: revisions,
+		stop:      make(chan struct{}),
+	}, nil
 }
 
-func (r *Roll) Roll() error {
-	for _, node := range r.Nodes {
+// NodeFunc performs a single attempt of an operation against a node,
+// giving up once ctx is done
+type NodeFunc func(ctx context.Context, node string, report *NodeReport) error
 
+// Roll dispatches the action to each node in turn. Cancelling ctx aborts
+// the roll immediately, see Stop to finish the current node first.
+func (r *Roll) Roll(ctx context.Context) error {
+	// Dispatch tracks a single node at a time
+	return r.each(ctx, 1, func(ctx context.Context, node string, report *NodeReport) error {
 		// roll the thing
-		err := r.Dispatch(node)
+		r.curAttempt = report.Attempts
+		err := r.Dispatch(ctx, node)
 		// hack for now (debug possible event dedup, watch exec race)
 		time.Sleep(1 * time.Second)
 
-		if err != nil {
+		report.Changes = r.changes
+
+		return err
+	})
+}
+
+// Each runs fn against the roll's nodes in order, Concurrency nodes at a
+// time, applying the retry policy and stopping at the first batch with
+// an error
+func (r *Roll) Each(ctx context.Context, fn NodeFunc) error {
+	return r.each(ctx, r.Concurrency, fn)
+}
+
+// Stop ends the roll once the current batch completes
+func (r *Roll) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+func (r *Roll) each(ctx context.Context, concurrency int, fn NodeFunc) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	r.history.Action = r.Action
+	r.history.Nodes = r.Nodes
+	r.history.Started = time.Now()
+	r.history.Result = "running"
+	r.history.RollbackOf = r.RollbackOf
+	r.history.Revisions = make(map[string]string)
+	r.history.Previous = make(map[string]string)
+
+	for _, node := range r.Nodes {
+		if rev := r.Revision(node); rev != "" {
+			r.history.Revisions[node] = rev
+		}
+
+		if rev := r.Previous[node]; rev != "" {
+			r.history.Previous[node] = rev
+		}
+	}
+
+	if err := PutHistory(r.kv, r.history); err != nil {
+		return err
+	}
+
+	r.startStatus(concurrency)
+
+	// Aborts requested with `cm abort` cancel ctx or stop the roll
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go r.watchAbort(ctx, cancel)
+
+	err := r.eachBatch(ctx, concurrency, fn)
+
+	r.mu.Lock()
+	r.history.AbortedBy = r.abortedBy
+	r.mu.Unlock()
+
+	r.history.Finished = time.Now()
+	r.history.Report = r.Report
+	switch err {
+	case nil:
+		r.history.Result = "success"
+	case ErrRollAborted:
+		r.history.Result = "aborted"
+	case ErrRollStopped:
+		r.history.Result = "stopped"
+	default:
+		r.history.Result = "fail"
+	}
+
+	if herr := PutHistory(r.kv, r.history); herr != nil && err == nil {
+		return herr
+	}
+
+	if perr := PruneHistory(r.kv, HistoryLimit); perr != nil {
+		fmt.Println("err: pruning history: ", perr)
+	}
+
+	if perr := PruneLogs(r.kv, time.Now()); perr != nil {
+		fmt.Println("err: pruning logs: ", perr)
+	}
+
+	return err
+}
+
+func (r *Roll) eachBatch(ctx context.Context, concurrency int, fn NodeFunc) error {
+	for i := 0; i < len(r.Nodes); i += concurrency {
+		if err := r.interrupted(ctx); err != nil {
 			return err
 		}
 
-		renew, _, err := r.session.Renew(r.sessionID, nil)
-		if err != nil {
+		end := i + concurrency
+		if end > len(r.Nodes) {
+			end = len(r.Nodes)
+		}
+
+		batch := r.Nodes[i:end]
+		errs := make([]error, len(batch))
+
+		var wg sync.WaitGroup
+		for j, node := range batch {
+			report := &NodeReport{Node: node}
+
+			r.mu.Lock()
+			r.Report = append(r.Report, report)
+			r.mu.Unlock()
+
+			wg.Add(1)
+			go func(j int, node string, report *NodeReport) {
+				defer wg.Done()
+
+				if errs[j] = r.waitWindow(ctx, node, report); errs[j] != nil {
+					state := report.Status
+					if state == "" {
+						state = "pending"
+					}
+
+					r.setState(node, state)
+					return
+				}
+
+				r.setState(node, "running")
+
+				start := time.Now()
+				errs[j] = r.attempt(ctx, node, report, fn)
+				report.Duration = time.Since(start)
+
+				r.setState(node, report.Status)
+			}(j, node, report)
+		}
+
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return ErrRollAborted
+		}
+
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+
+		if end < len(r.Nodes) {
+			if stage := r.pauseAt(i/concurrency+1, batch[len(batch)-1], r.Nodes[end]); stage != "" {
+				if err := r.gate(ctx, stage); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// attempt dispatches fn to a node, re-dispatching on failure or timeout
+// after RetryDelay until the retry policy is exhausted. The roll session
+// is renewed after each attempt and report.Status set from its result.
+// Aborts and other errors aren't retried.
+func (r *Roll) attempt(ctx context.Context, node string, report *NodeReport, fn NodeFunc) error {
+	for {
+		report.Attempts++
+
+		err := fn(ctx, node, report)
+
+		switch err {
+		case nil:
+			report.Status = "success"
+		case ErrNodeFailed:
+			report.Status = "fail"
+		case ErrNodeTimeout:
+			report.Status = "timeout"
+		case ErrRollAborted:
+			report.Status = "aborted"
+			return err
+		default:
+			report.Status = "error"
 			return err
 		}
 
-		if renew == nil {
-			return errors.New("err: session renewal failed")
+		if rerr := r.renew(); rerr != nil {
+			return rerr
 		}
+
+		if err == nil || report.Attempts > r.Retries {
+			return err
+		}
+
+		r.Msg <- "retry"
+		if err := sleep(ctx, r.RetryDelay, nil); err != nil {
+			report.Status = "aborted"
+			return err
+		}
+	}
+}
+
+// interrupted reports whether the roll was aborted or stopped
+func (r *Roll) interrupted(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ErrRollAborted
+	case <-r.stop:
+		return ErrRollStopped
+	default:
+		return nil
+	}
+}
+
+// sleep waits for d, returning early with ErrRollAborted once ctx is done
+// or ErrRollStopped once stop is closed
+func sleep(ctx context.Context, d time.Duration, stop <-chan struct{}) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ErrRollAborted
+	case <-stop:
+		return ErrRollStopped
+	}
+}
+
+// waitWindow refuses a node outside the maintenance windows of its roles,
+// or holds it until they open when WaitForWindow is set
+func (r *Roll) waitWindow(ctx context.Context, node string, report *NodeReport) error {
+	for {
+		now := time.Now()
+
+		next := r.schedule.NextEligible(node, now)
+		if next.Equal(now) {
+			return nil
+		}
+
+		if next.IsZero() {
+			report.Status = "outside window"
+			return errors.New(fmt.Sprintf("err: %s has no open maintenance window", node))
+		}
+
+		if !r.WaitForWindow {
+			report.Status = "outside window"
+			return errors.New(fmt.Sprintf("err: %s is outside its maintenance window until %s (use --wait-window to wait)", node, next.Format(time.RFC3339)))
+		}
+
+		r.setState(node, "waiting")
+		r.Msg <- fmt.Sprintf("%s %s %s", WindowMsg, node, next.Format(time.RFC3339))
+
+		// keep the session alive while waiting
+		for time.Now().Before(next) {
+			wait := next.Sub(time.Now())
+			if wait > time.Minute {
+				wait = time.Minute
+			}
+
+			if err := sleep(ctx, wait, r.stop); err != nil {
+				return err
+			}
+
+			if err := r.renew(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *Roll) renew() error {
+	renew, _, err := r.session.Renew(r.sessionID, nil)
+	if err != nil {
+		return err
+	}
+
+	if renew == nil {
+		return errors.New("err: session renewal failed")
 	}
 
 	return nil
 }
 
-func (r *Roll) Dispatch(host string) error {
+func (r *Roll) Dispatch(ctx context.Context, host string) error {
 	// Setup event
-	cascadeEvent := CascadeEvent{"cascade cli", "run", ""}
-	payload, _ := json.Marshal(cascadeEvent)
-	nodeFilter := fmt.Sprintf("^%s", host)
-	params := &api.UserEvent{Name: "cascade.cm", Payload: payload, NodeFilter: nodeFilter}
+	cascadeEvent := CascadeEvent{Source: "cascade cli", Msg: r.Action, Roll: r.ID, Attempt: r.curAttempt, Revision: r.Revision(host)}
+	if len(r.Params) > 0 {
+		cascadeEvent.Params = &EventParams{Version: ParamsVersion, Values: r.Params}
+	}
+
+	payload, err := r.eventPayload(cascadeEvent)
+	if err != nil {
+		return err
+	}
+
+	nodeFilter := fmt.Sprintf("^%s$", regexp.QuoteMeta(host))
+	params := &api.UserEvent{Name: EventName, Payload: payload, NodeFilter: nodeFilter}
 
 	var errExit error
+	var changes int
+	r.changes = 0
 
 	// Setup watch
 	watchParams := make(map[string]interface{})
 	watchParams["type"] = "event"
-	watchParams["name"] = "cascade.cm"
+	watchParams["name"] = EventName
 
 	watch, err := watch.Parse(watchParams)
 	if err != nil {
@@ -171,10 +572,11 @@ func (r *Roll) Dispatch(host string) error {
 				r.Msg <- e.Msg
 
 				if e.Msg == "success" || e.Msg == "fail" {
-					r.watch.Stop()
+					watch.Stop()
+					changes = e.Changes
 
 					if e.Msg == "fail" {
-						errExit = errors.New("err: failure roll stopped")
+						errExit = ErrNodeFailed
 					}
 				}
 			}
@@ -194,15 +596,45 @@ func (r *Roll) Dispatch(host string) error {
 	r.Msg <- host
 
 	// Execute Watch
-	if err := r.watch.Run(ConsulHost); err != nil {
-		return errors.New(fmt.Sprintf("err: querying Consul agent: %s", err))
+	done := make(chan error, 1)
+	go func() {
+		done <- r.watch.Run(ConsulHost)
+	}()
+
+	var timeout <-chan time.Time
+	if r.Timeout > 0 {
+		timeout = time.After(r.Timeout)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.New(fmt.Sprintf("err: querying Consul agent: %s", err))
+		}
+
+		r.changes = changes
+	case <-timeout:
+		r.watch.Stop()
+		r.Msg <- "timeout"
+		return ErrNodeTimeout
+	case <-ctx.Done():
+		r.watch.Stop()
+		return ErrRollAborted
 	}
 
 	return errExit
 }
 
 func (r *Roll) Destroy() error {
-	r.watch.Stop()
+	if r.watch != nil {
+		r.watch.Stop()
+	}
+
+	if r.paramsPair != nil {
+		r.kv.Delete(r.paramsPair.Key, nil)
+	}
+
+	r.kv.Delete(AbortKey, nil)
 
 	if work, _, err := r.kv.Release(r.pair, nil); err != nil {
 		return err
@@ -214,7 +646,41 @@ func (r *Roll) Destroy() error {
 	return nil
 }
 
+// ValidateAction checks an action name can be carried in a CascadeEvent
+func ValidateAction(action string) error {
+	if action == "" {
+		return errors.New("err: action must not be empty")
+	}
+
+	for _, msg := range replyMsgs {
+		if action == msg {
+			return errors.New(fmt.Sprintf("err: %s is reserved and can't be used as an action", action))
+		}
+	}
+
+	return nil
+}
+
+// CurrentUser is the operator, seen through sudo
+func CurrentUser() string {
+	user := os.Getenv("USER")
+
+	if user == "root" && os.Getenv("SUDO_USER") != "" {
+		user = os.Getenv("SUDO_USER")
+	}
+
+	return user
+}
+
+// GetNodes returns the nodes to roll for a role in run order, skipping
+// cordoned nodes
 func GetNodes(role string) ([]string, error) {
+	nodes, _, err := getNodes(role)
+	return nodes, err
+}
+
+// getNodes also returns the run_order role each node is rolled under
+func getNodes(role string) ([]string, map[string]string, error) {
 	client, _ := api.NewClient(api.DefaultConfig())
 	catalog := client.Catalog()
 	kv := client.KV()
@@ -223,17 +689,30 @@ func GetNodes(role string) ([]string, error) {
 	// We have to use arrays to preserve order :(
 	seen := make(map[string]bool)
 	result := make([]string, 0)
+	stages := make(map[string]string)
 
-	nodes, _, err := catalog.Service("cascade", role, nil)
+	services, _, err := catalog.Service("cascade", role, nil)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	cordons, err := GetCordons(kv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nodes := make([]*api.CatalogService, 0)
+	for _, node := range services {
+		if cordons[node.Node] == nil {
+			nodes = append(nodes, node)
+		}
 	}
 
 	pair, _, err := kv.Get(RunOrderKey, nil)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if pair == nil {
@@ -247,7 +726,7 @@ func GetNodes(role string) ([]string, error) {
 
 		err = yaml.Unmarshal([]byte(pair.Value), &roles)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		// TODO this is gross
@@ -258,6 +737,7 @@ func GetNodes(role string) ([]string, error) {
 				for _, nodeRole := range node.ServiceTags {
 					if role == nodeRole && !seen[node.Node] {
 						seen[node.Node] = true
+						stages[node.Node] = role
 						tmp = append(tmp, node.Node)
 					}
 				}
@@ -273,5 +753,29 @@ func GetNodes(role string) ([]string, error) {
 
 	}
 
-	return result, err
+	return result, stages, err
+}
+
+// GetCordoned returns the cordoned nodes for a role
+func GetCordoned(role string) ([]*Cordon, error) {
+	client, _ := api.NewClient(api.DefaultConfig())
+
+	nodes, _, err := client.Catalog().Service("cascade", role, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cordons, err := GetCordons(client.KV())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Cordon, 0)
+	for _, node := range nodes {
+		if c := cordons[node.Node]; c != nil {
+			result = append(result, c)
+		}
+	}
+
+	return result, nil
 }
@@ -0,0 +1,178 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// StatusKey holds the progress of the running roll. It is held by the
+// roll's session so disappears with it.
+const StatusKey = "cascade/status"
+
+type NodeStatus struct {
+	Node     string        `json:"node"`
+	State    string        `json:"state"`
+	Started  time.Time     `json:"started,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+type Status struct {
+	ID          string        `json:"id"`
+	User        string        `json:"user"`
+	Role        string        `json:"role"`
+	Action      string        `json:"action"`
+	Concurrency int           `json:"concurrency"`
+	Started     time.Time     `json:"started"`
+	Paused      string        `json:"paused,omitempty"`
+	Nodes       []*NodeStatus `json:"nodes"`
+}
+
+// GetStatus returns the progress of the running roll, nil if there is none
+func GetStatus(kv *api.KV, q *api.QueryOptions) (*Status, *api.QueryMeta, error) {
+	pair, meta, err := kv.Get(StatusKey, q)
+	if err != nil || pair == nil {
+		return nil, meta, err
+	}
+
+	s := &Status{}
+	if err := json.Unmarshal(pair.Value, s); err != nil {
+		return nil, meta, err
+	}
+
+	return s, meta, nil
+}
+
+// ETA estimates the time left from the durations nodes took in previous
+// rolls of the same action, false if there is nothing to go by
+func (s *Status) ETA(history []*History, now time.Time) (time.Duration, bool) {
+	last := make(map[string]time.Duration)
+	var total time.Duration
+
+	// history is oldest first so the latest duration wins
+	for _, h := range history {
+		if h.ID == s.ID || h.Action != s.Action {
+			continue
+		}
+
+		for _, r := range h.Report {
+			if r.Status == "success" {
+				last[r.Node] = r.Duration
+			}
+		}
+	}
+
+	for _, d := range last {
+		total += d
+	}
+
+	if len(last) == 0 {
+		return 0, false
+	}
+
+	mean := total / time.Duration(len(last))
+
+	var remaining time.Duration
+	for _, n := range s.Nodes {
+		estimate, ok := last[n.Node]
+		if !ok {
+			estimate = mean
+		}
+
+		switch n.State {
+		case "pending", "waiting":
+			remaining += estimate
+		case "running":
+			if left := estimate - now.Sub(n.Started); left > 0 {
+				remaining += left
+			}
+		}
+	}
+
+	if s.Concurrency > 1 {
+		remaining /= time.Duration(s.Concurrency)
+	}
+
+	return remaining, true
+}
+
+// startStatus publishes every node of the roll as pending
+func (r *Roll) startStatus(concurrency int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status = &Status{
+		ID:          r.ID,
+		User:        r.history.User,
+		Role:        r.history.Role,
+		Action:      r.Action,
+		Concurrency: concurrency,
+		Started:     r.history.Started,
+	}
+
+	for _, node := range r.Nodes {
+		r.status.Nodes = append(r.status.Nodes, &NodeStatus{Node: node, State: "pending"})
+	}
+
+	r.publish()
+}
+
+// setState publishes the state of a node, timing it while running
+func (r *Roll) setState(node string, state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, n := range r.status.Nodes {
+		if n.Node != node {
+			continue
+		}
+
+		if state == "running" && n.State != "running" {
+			n.Started = time.Now()
+		} else if n.State == "running" {
+			n.Duration = time.Since(n.Started)
+		}
+
+		n.State = state
+	}
+
+	r.publish()
+}
+
+// setPaused publishes the gate the roll waits at, empty once past it
+func (r *Roll) setPaused(stage string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.Paused = stage
+	r.publish()
+}
+
+// publish writes the status, the caller holds r.mu. Progress is best
+// effort and never fails the roll.
+func (r *Roll) publish() {
+	value, err := json.Marshal(r.status)
+	if err != nil {
+		return
+	}
+
+	r.kv.Acquire(&api.KVPair{Key: StatusKey, Value: value, Session: r.sessionID}, nil)
+}
@@ -0,0 +1,297 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"gopkg.in/yaml.v2"
+)
+
+const WindowsPrefix = "cascade/windows/"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a recurring maintenance window such as
+// "mon-fri 22:00-04:00 Europe/London". Days are when the window opens,
+// a window ending at or before its start closes the following day.
+type Window struct {
+	Spec     string
+	days     [7]bool
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+}
+
+func ParseWindow(spec string) (*Window, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, errors.New(fmt.Sprintf("err: window must be `<days> <hh:mm>-<hh:mm> [timezone]`: %s", spec))
+	}
+
+	w := &Window{Spec: spec, location: time.UTC}
+
+	if err := w.parseDays(fields[0]); err != nil {
+		return nil, err
+	}
+
+	hours := strings.Split(fields[1], "-")
+	if len(hours) != 2 {
+		return nil, errors.New(fmt.Sprintf("err: invalid hours: %s", fields[1]))
+	}
+
+	var err error
+	if w.start, err = parseClock(hours[0]); err != nil {
+		return nil, err
+	}
+
+	if w.end, err = parseClock(hours[1]); err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 3 {
+		if w.location, err = time.LoadLocation(fields[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+func (w *Window) parseDays(days string) error {
+	if days == "*" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+
+		return nil
+	}
+
+	for _, part := range strings.Split(days, ",") {
+		bounds := strings.Split(part, "-")
+
+		first, ok := weekdays[bounds[0]]
+		last := first
+		if ok && len(bounds) == 2 {
+			last, ok = weekdays[bounds[1]]
+		}
+
+		if !ok || len(bounds) > 2 {
+			return errors.New(fmt.Sprintf("err: invalid days: %s", part))
+		}
+
+		// ranges may wrap, e.g. fri-mon
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseClock(clock string) (time.Duration, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(clock, "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, errors.New(fmt.Sprintf("err: invalid time: %s", clock))
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// open returns the occurrence of the window opening on the day of t.
+// Times are wall clock times, so windows keep their hours on the days
+// daylight saving changes.
+func (w *Window) open(t time.Time) (time.Time, time.Time, bool) {
+	at := func(clock time.Duration, days int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+days, int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, w.location)
+	}
+
+	start := at(w.start, 0)
+
+	end := at(w.end, 0)
+	if w.end <= w.start {
+		end = at(w.end, 1)
+	}
+
+	return start, end, w.days[at(0, 0).Weekday()]
+}
+
+// Contains reports whether the window is open at t
+func (w *Window) Contains(t time.Time) bool {
+	t = t.In(w.location)
+
+	// an occurrence opening yesterday may still be open
+	for _, day := range []time.Time{t.AddDate(0, 0, -1), t} {
+		start, end, ok := w.open(day)
+		if ok && !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Next returns the earliest time at or after t the window is open
+func (w *Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+
+	local := t.In(w.location)
+	for d := 0; d <= 7; d++ {
+		start, _, ok := w.open(local.AddDate(0, 0, d))
+		if ok && !start.Before(t) {
+			return start
+		}
+	}
+
+	// no days set, never open
+	return time.Time{}
+}
+
+// GetWindows returns maintenance windows keyed by role
+func GetWindows(kv *api.KV) (map[string][]*Window, error) {
+	pairs, _, err := kv.List(WindowsPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	windows := make(map[string][]*Window)
+	for _, pair := range pairs {
+		role := strings.TrimPrefix(pair.Key, WindowsPrefix)
+
+		specs := make([]string, 0)
+		if err := yaml.Unmarshal(pair.Value, &specs); err != nil {
+			return nil, errors.New(fmt.Sprintf("err: parsing %s: %s", pair.Key, err))
+		}
+
+		for _, spec := range specs {
+			w, err := ParseWindow(spec)
+			if err != nil {
+				return nil, err
+			}
+
+			windows[role] = append(windows[role], w)
+		}
+	}
+
+	return windows, nil
+}
+
+// PutWindows replaces the windows of a role, no specs removes them
+func PutWindows(kv *api.KV, role string, specs []string) error {
+	if len(specs) == 0 {
+		_, err := kv.Delete(WindowsPrefix+role, nil)
+		return err
+	}
+
+	for _, spec := range specs {
+		if _, err := ParseWindow(spec); err != nil {
+			return err
+		}
+	}
+
+	value, err := yaml.Marshal(specs)
+	if err != nil {
+		return err
+	}
+
+	_, err = kv.Put(&api.KVPair{Key: WindowsPrefix + role, Value: value}, nil)
+	return err
+}
+
+// Schedule knows when nodes may be rolled from the windows of their roles
+type Schedule struct {
+	roles   map[string][]string
+	windows map[string][]*Window
+}
+
+func LoadSchedule(client *api.Client) (*Schedule, error) {
+	windows, err := GetWindows(client.KV())
+	if err != nil {
+		return nil, err
+	}
+
+	services, _, err := client.Catalog().Service("cascade", "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string][]string)
+	for _, service := range services {
+		roles[service.Node] = service.ServiceTags
+	}
+
+	return &Schedule{roles, windows}, nil
+}
+
+// NextEligible returns the earliest time at or after t that a window is
+// open for every one of the node's roles that has windows. The zero time
+// means the node never becomes eligible.
+func (s *Schedule) NextEligible(node string, t time.Time) time.Time {
+	// each pass moves t to the next opening of a closed role, settling
+	// once all roles are open together
+	for pass := 0; pass < 16; pass++ {
+		moved := false
+
+		for _, role := range s.roles[node] {
+			windows := s.windows[role]
+			if len(windows) == 0 {
+				continue
+			}
+
+			var next time.Time
+			for _, w := range windows {
+				n := w.Next(t)
+				if !n.IsZero() && (next.IsZero() || n.Before(next)) {
+					next = n
+				}
+			}
+
+			if next.IsZero() {
+				return next
+			}
+
+			if next.After(t) {
+				t = next
+				moved = true
+			}
+		}
+
+		if !moved {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,168 @@
+//
+// Author:: Zachary Schneider (<schneider@boundary.com>)
+// Copyright:: Copyright (c) 2015 Boundary, Inc.
+// License:: Apache License, Version 2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roll
+
+import (
+	"testing"
+	"time"
+)
+
+func mustTime(t *testing.T, s string) time.Time {
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tm
+}
+
+func mustWindow(t *testing.T, spec string) *Window {
+	w, err := ParseWindow(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"mon-fri 22:00-04:00 Europe/London", true},
+		{"* 00:00-24:00", true},
+		{"fri-mon 01:00-02:00", true},
+		{"sat,sun 10:00-12:00 UTC", true},
+		{"mon", false},
+		{"mon 01:00", false},
+		{"xyz 01:00-02:00", false},
+		{"mon-tue-wed 01:00-02:00", false},
+		{"mon 25:00-02:00", false},
+		{"mon 24:30-01:00", false},
+		{"mon 01:60-02:00", false},
+		{"mon 01:00-02:00 Nowhere/City", false},
+		{"mon 01:00-02:00 UTC extra", false},
+	}
+
+	for _, test := range tests {
+		_, err := ParseWindow(test.spec)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseWindow(%q) err = %v, want ok %v", test.spec, err, test.ok)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	tests := []struct {
+		spec string
+		at   string
+		want bool
+	}{
+		{"mon-fri 22:00-04:00", "2026-10-19T23:00:00Z", true},
+		{"mon-fri 22:00-04:00", "2026-10-19T21:59:00Z", false},
+		{"mon-fri 22:00-04:00", "2026-10-20T03:59:00Z", true},
+		{"mon-fri 22:00-04:00", "2026-10-20T04:00:00Z", false},
+		// opened friday
+		{"mon-fri 22:00-04:00", "2026-10-24T03:00:00Z", true},
+		{"mon-fri 22:00-04:00", "2026-10-25T03:00:00Z", false},
+		{"fri-mon 01:00-02:00", "2026-10-25T01:30:00Z", true},
+		{"fri-mon 01:00-02:00", "2026-10-21T01:30:00Z", false},
+		{"* 00:00-24:00", "2026-10-21T13:00:00Z", true},
+		// 09:30 BST, then 08:30 GMT once daylight saving ends
+		{"* 09:00-17:00 Europe/London", "2026-10-19T08:30:00Z", true},
+		{"* 09:00-17:00 Europe/London", "2026-10-26T08:30:00Z", false},
+		// on the days daylight saving ends and starts
+		{"* 22:00-23:00 Europe/London", "2026-10-25T21:30:00Z", false},
+		{"* 22:00-23:00 Europe/London", "2026-10-25T22:30:00Z", true},
+		{"* 09:00-10:00 Europe/London", "2026-03-29T08:30:00Z", true},
+		{"* 09:00-10:00 Europe/London", "2026-03-29T09:30:00Z", false},
+	}
+
+	for _, test := range tests {
+		if got := mustWindow(t, test.spec).Contains(mustTime(t, test.at)); got != test.want {
+			t.Errorf("%q Contains(%s) = %v, want %v", test.spec, test.at, got, test.want)
+		}
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"mon-fri 22:00-04:00", "2026-10-24T12:00:00Z", "2026-10-26T22:00:00Z"},
+		{"mon-fri 22:00-04:00", "2026-10-19T23:00:00Z", "2026-10-19T23:00:00Z"},
+		{"mon-fri 22:00-04:00", "2026-10-19T21:00:00Z", "2026-10-19T22:00:00Z"},
+		{"sat 10:00-11:00 Europe/London", "2026-10-19T00:00:00Z", "2026-10-24T09:00:00Z"},
+		{"sat 10:00-11:00 Europe/London", "2026-10-25T00:00:00Z", "2026-10-31T10:00:00Z"},
+		{"sun 22:00-23:00 Europe/London", "2026-10-25T00:00:00Z", "2026-10-25T22:00:00Z"},
+	}
+
+	for _, test := range tests {
+		got := mustWindow(t, test.spec).Next(mustTime(t, test.from))
+		if want := mustTime(t, test.want); !got.Equal(want) {
+			t.Errorf("%q Next(%s) = %s, want %s", test.spec, test.from, got.Format(time.RFC3339), test.want)
+		}
+	}
+}
+
+func TestNextEligible(t *testing.T) {
+	s := &Schedule{
+		roles: map[string][]string{
+			"open":     {"cache"},
+			"both":     {"web", "db"},
+			"disjoint": {"x", "y"},
+		},
+		windows: map[string][]*Window{
+			"db":  {mustWindow(t, "mon-fri 22:00-04:00")},
+			"web": {mustWindow(t, "* 02:00-06:00"), mustWindow(t, "sun 12:00-13:00")},
+			"x":   {mustWindow(t, "mon 01:00-02:00")},
+			"y":   {mustWindow(t, "tue 01:00-02:00")},
+		},
+	}
+
+	tests := []struct {
+		node string
+		from string
+		want string
+	}{
+		{"open", "2026-10-19T12:00:00Z", "2026-10-19T12:00:00Z"},
+		{"unknown", "2026-10-19T12:00:00Z", "2026-10-19T12:00:00Z"},
+		{"both", "2026-10-19T12:00:00Z", "2026-10-20T02:00:00Z"},
+		{"both", "2026-10-20T03:00:00Z", "2026-10-20T03:00:00Z"},
+		{"both", "2026-10-24T12:00:00Z", "2026-10-27T02:00:00Z"},
+		{"disjoint", "2026-10-19T12:00:00Z", ""},
+	}
+
+	for _, test := range tests {
+		got := s.NextEligible(test.node, mustTime(t, test.from))
+
+		if test.want == "" {
+			if !got.IsZero() {
+				t.Errorf("NextEligible(%s, %s) = %s, want never", test.node, test.from, got.Format(time.RFC3339))
+			}
+			continue
+		}
+
+		if want := mustTime(t, test.want); !got.Equal(want) {
+			t.Errorf("NextEligible(%s, %s) = %s, want %s", test.node, test.from, got.Format(time.RFC3339), test.want)
+		}
+	}
+}